@@ -0,0 +1,261 @@
+package bucketscanner
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Download output formats
+const (
+	ZipFormat   = "zip"
+	TarGzFormat = "tar.gz"
+	DirFormat   = "dir" // A directory tree mirroring the object keys
+)
+
+// outputTimeFormat is the basic ISO 8601 UTC timestamp of download output names, which unlike
+// RFC3339 has no colons so is valid on every filesystem
+const outputTimeFormat = "20060102T150405Z"
+
+// archiveWriter adds downloaded objects to a download output
+type archiveWriter interface {
+	addDir(bucketFile *File) error
+	addFile(bucketFile *File, size int64, body io.Reader) error
+	Close() error
+}
+
+// safeName returns the bucket name usable within a file name e.g. Azure's account/container
+func safeName(name string) string {
+	return strings.NewReplacer("/", "-", `\`, "-", ":", "-").Replace(name)
+}
+
+// outputPath returns the path of a new download output of the bucket in the format within the
+// destination directory
+func outputPath(destDir string, name string, format string) string {
+	outPath := destDir + "bucket-" + safeName(name) + "-" + time.Now().UTC().Format(outputTimeFormat)
+	if format == DirFormat {
+		return outPath
+	}
+	return outPath + "." + format
+}
+
+// safeKey reports whether the object key can be written beneath a destination directory, so is
+// not absolute, does not traverse above it with .. and has no empty or . segments naming the
+// directory itself. Directory keys may end with a slash.
+func safeKey(key string) bool {
+	if key == "" || strings.ContainsRune(key, 0) || path.IsAbs(key) || strings.HasPrefix(key, `\`) {
+		return false
+	}
+
+	// Windows drive letters e.g. C:\ or C:/
+	if len(key) >= 2 && key[1] == ':' && (key[0]|0x20 >= 'a' && key[0]|0x20 <= 'z') {
+		return false
+	}
+
+	key = strings.ReplaceAll(strings.TrimSuffix(key, "/"), `\`, "/")
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// newArchiveWriter creates the download output of the format at the given path
+func newArchiveWriter(outPath string, format string) (archiveWriter, error) {
+	switch format {
+	case "", ZipFormat:
+		newfile, err := os.Create(outPath)
+		if err != nil {
+			return nil, err
+		}
+		return &zipArchive{file: newfile, writer: zip.NewWriter(newfile)}, nil
+	case TarGzFormat:
+		newfile, err := os.Create(outPath)
+		if err != nil {
+			return nil, err
+		}
+		gz := gzip.NewWriter(newfile)
+		return &tarArchive{file: newfile, gzip: gz, writer: tar.NewWriter(gz)}, nil
+	case DirFormat:
+		if err := os.MkdirAll(outPath, 0755); err != nil {
+			return nil, err
+		}
+		return dirMirror(outPath), nil
+	}
+
+	return nil, fmt.Errorf("Unsupported download format %s: %w", format, ErrUnsupported)
+}
+
+// zipArchive writes the objects to a zip archive
+type zipArchive struct {
+	file   *os.File
+	writer *zip.Writer
+}
+
+func (z *zipArchive) addDir(bucketFile *File) error {
+	_, err := z.writer.CreateHeader(&zip.FileHeader{Name: bucketFile.Name, Modified: bucketFile.LastModified})
+	return err
+}
+
+func (z *zipArchive) addFile(bucketFile *File, size int64, body io.Reader) error {
+	header := &zip.FileHeader{Name: bucketFile.Name, Method: zip.Deflate, Modified: bucketFile.LastModified}
+	zipFile, err := z.writer.CreateHeader(header)
+	if err != nil {
+		return fmt.Errorf("Failed to create file in archive: %w", err)
+	}
+	_, err = io.Copy(zipFile, body)
+	return err
+}
+
+func (z *zipArchive) Close() error {
+	err := z.writer.Close()
+	if closeErr := z.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// tarArchive writes the objects to a gzip compressed tar archive
+type tarArchive struct {
+	file   *os.File
+	gzip   *gzip.Writer
+	writer *tar.Writer
+}
+
+func (t *tarArchive) addDir(bucketFile *File) error {
+	return t.writer.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     bucketFile.Name,
+		Mode:     0755,
+		ModTime:  bucketFile.LastModified,
+	})
+}
+
+func (t *tarArchive) addFile(bucketFile *File, size int64, body io.Reader) error {
+	err := t.writer.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     bucketFile.Name,
+		Mode:     0644,
+		Size:     size,
+		ModTime:  bucketFile.LastModified,
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to create file in archive: %w", err)
+	}
+	_, err = io.Copy(t.writer, body)
+	return err
+}
+
+func (t *tarArchive) Close() error {
+	err := t.writer.Close()
+	if gzErr := t.gzip.Close(); err == nil {
+		err = gzErr
+	}
+	if closeErr := t.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// pathError is an object the output cannot write at the path of its key e.g. a file key clashing
+// with a directory of the same name, which rejects only that object
+type pathError struct {
+	error
+}
+
+// dirMirror writes the objects beneath a directory at the paths of their keys
+type dirMirror string
+
+// path returns the local path of the bucket file within the mirror
+func (d dirMirror) path(bucketFile *File) string {
+	return filepath.Join(string(d), filepath.FromSlash(bucketFile.Name))
+}
+
+func (d dirMirror) addDir(bucketFile *File) error {
+	if err := os.MkdirAll(d.path(bucketFile), 0755); err != nil {
+		return pathError{err}
+	}
+	return nil
+}
+
+func (d dirMirror) addFile(bucketFile *File, size int64, body io.Reader) error {
+	local := d.path(bucketFile)
+	if err := os.MkdirAll(filepath.Dir(local), 0755); err != nil {
+		return pathError{err}
+	}
+
+	newfile, err := os.Create(local)
+	if err != nil {
+		return pathError{err}
+	}
+	_, err = io.Copy(newfile, body)
+	if closeErr := newfile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && !bucketFile.LastModified.IsZero() {
+		err = os.Chtimes(local, bucketFile.LastModified, bucketFile.LastModified)
+	}
+	return err
+}
+
+func (d dirMirror) Close() error {
+	return nil
+}
+
+// writeOutput writes the staged objects of the bucket files to a new download output of the
+// format at the given path, returning the keys of the objects it could not write at their paths
+func writeOutput(outPath string, format string, objectsDir string, files []*File) (rejected map[string]bool, err error) {
+	output, err := newArchiveWriter(outPath, format)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := output.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	rejected = make(map[string]bool)
+	for _, bucketFile := range files {
+		if bucketFile.IsDir {
+			err = output.addDir(bucketFile)
+		} else {
+			err = addStaged(output, objectsDir, bucketFile)
+		}
+
+		var pathErr pathError
+		if errors.As(err, &pathErr) {
+			rejected[bucketFile.Name] = true
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Failed to write file [%s] to %s: %w", bucketFile.Name, outPath, err)
+		}
+	}
+
+	return rejected, nil
+}
+
+// addStaged adds the staged object of the bucket file to the output
+func addStaged(output archiveWriter, objectsDir string, bucketFile *File) error {
+	staged, err := os.Open(stagedPath(objectsDir, bucketFile.Name))
+	if err != nil {
+		return err
+	}
+	defer staged.Close()
+
+	fi, err := staged.Stat()
+	if err != nil {
+		return err
+	}
+	return output.addFile(bucketFile, fi.Size(), staged)
+}
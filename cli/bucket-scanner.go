@@ -1,20 +1,37 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"gitlab.com/cjbarker/bucketscanner"
+	"gitlab.com/cjbarker/bucketscanner/wordlist"
 	"gopkg.in/alecthomas/kingpin.v2"
+	"io"
+	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
-	"sync"
+	"syscall"
 	"time"
 )
 
 // Exit Codes
 const (
-	Success      = 0
-	InvalidCloud = 100
+	Success         = 0
+	InvalidCloud    = 100
+	InvalidAction   = 101
+	InvalidInput    = 102
+	InvalidName     = 103 // A bucket name was invalid for a provider
+	NetworkFailure  = 104 // A request failed to reach a provider
+	HTTPFailure     = 105 // A provider responded with an unexpected HTTP status
+	Unsupported     = 106 // A scan action is not supported by a provider
+	DownloadFailure = 107 // A bucket or some of its files failed to download
+	ScanFailure     = 108 // A scan failed for any other reason
+	Incomplete      = 109 // The scan was interrupted or reached its deadline
 )
 
 // Provider
@@ -33,41 +50,62 @@ const (
 
 // Config is struct representing the Commandline argument settings
 type Config struct {
-	BucketNames   *string
-	Action        *string
-	Download      *bool
-	Output        *string
-	Verbose       *bool
-	CloudProvider *string
-	ThrottleMs    *int
-	JSON          *bool
+	BucketNames     *string
+	Wordlists       *[]string
+	Action          *string
+	Download        *bool
+	Output          *string
+	Verbose         *bool
+	CloudProvider   *string
+	ThrottleMs      *int
+	RetryPasses     *int
+	Timeout         *time.Duration
+	Deadline        *time.Duration
+	Workers         *int
+	ProviderWorkers *map[string]int
+	MaxObjects      *int
+	JSON            *bool
+	Format          *string
+	Rows            *string
+	Tree            *bool
+	TreeDepth       *int
+	Endpoint        *string
+	PathStyle       *bool
+	Insecure        *bool
+	Downloads       *DownloadConfig
+	Proxies         *ProxyConfig
+	Generate        *GenerateConfig
 }
 
 func (c Config) v(msg string) {
 	if *c.Verbose {
-		fmt.Printf("%s\n", msg)
+		fmt.Fprintf(diagnostics, "%s\n", msg)
 	}
 }
 
 // Globals
 var configPtr *Config
 
-func getScanner(providerName *string) (scanners []bucketscanner.Scanner) {
+// diagnostics is written the verbose, download and proxy messages, which is stderr when the
+// results are streamed to stdout so they stay parsable
+var diagnostics io.Writer = os.Stdout
+
+func getScanner(providerName *string, opts ...bucketscanner.Option) (scanners []bucketscanner.Scanner) {
 	if providerName == nil || strings.Trim(*providerName, " ") == "" {
 		return nil
 	}
 
 	//var scanners []*Scanner
 	if strings.ToLower(*providerName) == All {
-		scanners = append(scanners, &bucketscanner.AwsScanner{})
-		scanners = append(scanners, &bucketscanner.GcpScanner{})
-		scanners = append(scanners, &bucketscanner.AzureScanner{})
+		scanners = append(scanners, bucketscanner.NewAwsScanner(opts...))
+		scanners = append(scanners, bucketscanner.NewGcpScanner(opts...))
+		scanners = append(scanners, bucketscanner.NewAzureScanner(opts...))
 	} else if strings.ToLower(*providerName) == AwsProvider {
-		scanners = append(scanners, &bucketscanner.AwsScanner{})
+		scanners = append(scanners, bucketscanner.NewAwsScanner(opts...))
 	} else if strings.ToLower(*providerName) == GcpProvider {
-		scanners = append(scanners, &bucketscanner.GcpScanner{})
+		scanners = append(scanners, bucketscanner.NewGcpScanner(opts...))
 	} else if strings.ToLower(*providerName) == AzureProvider {
-		scanners = append(scanners, &bucketscanner.AzureScanner{})
+		scanners = append(scanners, bucketscanner.NewAzureScanner(opts...))
 	} else {
 		scanners = nil
 	}
@@ -75,103 +113,409 @@ func getScanner(providerName *string) (scanners []bucketscanner.Scanner) {
 	return scanners
 }
 
+// getOptions returns the scanner options of the command line settings
+func getOptions() (opts []bucketscanner.Option, err error) {
+	opts = append(opts, bucketscanner.WithMaxObjects(*configPtr.MaxObjects))
+	opts = append(opts, bucketscanner.WithTimeout(*configPtr.Timeout))
+	if *configPtr.ThrottleMs > 0 {
+		opts = append(opts, bucketscanner.WithRateLimit(1000/float64(*configPtr.ThrottleMs)))
+	}
+
+	if *configPtr.Endpoint != "" {
+		opts = append(opts, bucketscanner.WithEndpoint(*configPtr.Endpoint))
+	}
+	if *configPtr.PathStyle {
+		opts = append(opts, bucketscanner.WithPathStyle())
+	}
+	if *configPtr.Insecure || len(*configPtr.Proxies.Proxies) > 0 {
+		base := http.DefaultTransport.(*http.Transport).Clone()
+		if *configPtr.Insecure {
+			base.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		}
+		transport, err := configPtr.Proxies.transport(base)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, bucketscanner.WithHTTPClient(&http.Client{Transport: transport}))
+	}
+
+	return opts, nil
+}
+
+// splitNames streams the space or comma delimited bucket names
+func splitNames(bucketNames string) <-chan string {
+	names := make(chan string)
+
+	go func() {
+		defer close(names)
+
+		var split []string
+		if strings.Index(bucketNames, ",") > -1 {
+			split = strings.Split(bucketNames, ",")
+		} else {
+			split = strings.Split(bucketNames, " ")
+		}
+
+		for _, name := range split {
+			if name = strings.Trim(name, " "); name != "" {
+				names <- name
+			}
+		}
+	}()
+
+	return names
+}
+
+// inputNames lazily streams the de-duplicated bucket names passed as the argument followed by
+// the names read from the wordlists. A wordlist read error is sent once the names are closed.
+func inputNames(bucketNames string, wordlists []string) (<-chan string, <-chan error) {
+	names := make(chan string)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(names)
+
+		dedup := wordlist.NewDeduper()
+		for name := range splitNames(bucketNames) {
+			if !dedup.Seen(name) {
+				names <- name
+			}
+		}
+
+		reader := wordlist.New(wordlists...)
+		reader.Dedup = dedup
+		for name := range reader.Names() {
+			names <- name
+		}
+
+		if err := reader.Err(); err != nil {
+			errs <- err
+		}
+	}()
+
+	return names, errs
+}
+
+// providerWorkersValue parses the repeatable provider=workers flag
+type providerWorkersValue map[string]int
+
+func (p *providerWorkersValue) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("expected provider=workers got '%s'", value)
+	}
+
+	provider := strings.ToLower(strings.Trim(parts[0], " "))
+	if provider != AwsProvider && provider != GcpProvider && provider != AzureProvider {
+		return fmt.Errorf("invalid provider '%s'", parts[0])
+	}
+
+	workers, err := strconv.Atoi(strings.Trim(parts[1], " "))
+	if err != nil || workers <= 0 {
+		return fmt.Errorf("invalid number of workers '%s'", parts[1])
+	}
+
+	(*p)[provider] = workers
+	return nil
+}
+
+func (p *providerWorkersValue) String() string {
+	return fmt.Sprintf("%v", map[string]int(*p))
+}
+
+func (p *providerWorkersValue) IsCumulative() bool {
+	return true
+}
+
+// providerWorkers registers the provider workers flag returning its parsed values
+func providerWorkers(s kingpin.Settings) *map[string]int {
+	target := make(map[string]int)
+	s.SetValue((*providerWorkersValue)(&target))
+	return &target
+}
+
+// exitCode returns the exit code of a scan error
+func exitCode(err error) int {
+	switch {
+	case err == nil:
+		return Success
+	case errors.Is(err, bucketscanner.ErrInvalidName):
+		return InvalidName
+	case errors.Is(err, bucketscanner.ErrNetwork):
+		return NetworkFailure
+	case errors.Is(err, bucketscanner.ErrHTTPStatus):
+		return HTTPFailure
+	case errors.Is(err, bucketscanner.ErrUnsupported):
+		return Unsupported
+	}
+	return ScanFailure
+}
+
+// getAction returns the library scan action(s) of the command line action
+func getAction(action string) bucketscanner.Action {
+	switch action {
+	case Read:
+		return bucketscanner.ReadAction
+	case Write:
+		return bucketscanner.WriteAction
+	}
+	return bucketscanner.AllActions
+}
+
+// scan queues the bucket names against every scanner on the worker pool and collects the bucket
+// results, downloading their contents with the download options when set. Names are only queued
+// to scanners whose provider naming rules they pass when validate is set. With a stream the
+// results are written as they complete instead of collected. The exit status is of the first
+// failure, ignoring unsupported write actions of buckets that were read, which are only printed
+// when verbose.
+func scan(ctx context.Context, scanners []bucketscanner.Scanner, names <-chan string, validate bool, downloads *bucketscanner.DownloadOptions, stream reportWriter) (buckets []*bucketscanner.Bucket, status int) {
+	engine := bucketscanner.NewEngine(scanners...)
+	engine.Action = getAction(*configPtr.Action)
+	engine.Workers = *configPtr.Workers
+	engine.RetryPasses = *configPtr.RetryPasses
+	engine.Unordered = stream != nil
+	engine.ProviderWorkers = make(map[string]int)
+	for provider, workers := range *configPtr.ProviderWorkers {
+		for _, scanner := range getScanner(&provider) {
+			engine.ProviderWorkers[scanner.GetProviderName()] = workers
+		}
+	}
+	if validate {
+		engine.Validate = func(scanner bucketscanner.Scanner, name string) bool {
+			return getValidator(scanner)(name)
+		}
+	}
+
+	for result := range engine.RunContext(ctx, names) {
+		cleanupFailed := result.Bucket != nil && result.Bucket.Write != nil && result.Bucket.Write.CleanupFailed
+		if ctx.Err() != nil && errors.Is(result.Err, ctx.Err()) && !cleanupFailed {
+			// cancelled scans are not reported unless they left a canary object behind
+			continue
+		}
+
+		configPtr.v(fmt.Sprintf("Scanned from %s bucket: %s", result.Scanner.GetProviderName(), result.Name))
+
+		switch {
+		case result.Bucket != nil && errors.Is(result.Err, bucketscanner.ErrUnsupported):
+			// the write action of a provider without one is expected of every bucket it read
+			configPtr.v(result.Err.Error())
+		case result.Err != nil:
+			fmt.Fprintln(os.Stderr, result.Err)
+			if status == Success {
+				status = exitCode(result.Err)
+			}
+		}
+		if result.Bucket == nil {
+			continue
+		}
+
+		// the download report, including mismatched ETags, is output with the bucket
+		if downloads != nil {
+			report, code := download(ctx, result.Bucket, *downloads)
+			result.Bucket.DownloadReport = report
+			if status == Success {
+				status = code
+			}
+		}
+
+		if stream == nil {
+			buckets = append(buckets, result.Bucket)
+		} else if err := stream.write(result.Bucket); err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to write result of bucket "+result.Name+": "+err.Error())
+		}
+	}
+
+	return buckets, status
+}
+
 func main() {
 	configPtr = new(Config)
 
 	app := kingpin.New(os.Args[0], "Cloud command-line bucket (object) scanner.")
 	app.Version("Version: " + bucketscanner.Version + "\nBuild: " + bucketscanner.Build)
 
-	configPtr.BucketNames = app.Arg("bucket-name", "Bucket(s) name(s) to scan. Does support comma separated for multiple buckets.").Required().String()
+	scanCmd := app.Command("scan", "Scan the given bucket name(s).").Default()
+	configPtr.BucketNames = scanCmd.Arg("bucket-name", "Bucket(s) name(s) to scan. Does support comma separated for multiple buckets. Azure containers are named account/container.").String()
+	configPtr.Wordlists = scanCmd.Flag("wordlist", "Wordlist file of bucket names, one per line, optionally gzip compressed. Repeatable, pass - for stdin.").Strings()
 	configPtr.CloudProvider = app.Flag("cloud", "Cloud provider to scan: aws, gcp, azure. Defaults to all.").Required().String()
 	configPtr.Action = app.Flag("action", "Scan action to invoke against bucket: (r)ead, (w)rite, all. Defaults to all.").Required().String()
-	configPtr.ThrottleMs = app.Flag("throttle", "Time in milliseconds to throttle subsequent requests sent to a given provider.").Int()
+	configPtr.ThrottleMs = app.Flag("throttle", "Minimum time in milliseconds between requests sent to a given provider, which is slowed further while the provider throttles.").Int()
+	configPtr.RetryPasses = app.Flag("retry-passes", "Number of times buckets rate limited by their provider are rescanned once its other buckets are done.").Default(strconv.Itoa(bucketscanner.DefaultRetryPasses)).Int()
+	configPtr.Timeout = app.Flag("timeout", "Timeout of each request sent to a provider e.g. 10s, or of each wait for more of a downloading object.").Default(bucketscanner.DefaultTimeout.String()).Duration()
+	configPtr.Deadline = app.Flag("deadline", "Overall scan deadline e.g. 30m, after which partial results are output. Defaults to none.").Duration()
+	configPtr.Workers = app.Flag("workers", "Number of concurrent scan workers per provider.").Default(strconv.Itoa(bucketscanner.DefaultWorkers)).Int()
+	configPtr.ProviderWorkers = providerWorkers(app.Flag("provider-workers", "Number of concurrent scan workers for a given provider e.g. aws=20. Repeatable, overrides --workers."))
+	configPtr.MaxObjects = app.Flag("max-objects", "Maximum number of objects to list per bucket. Defaults to all.").Int()
+	configPtr.Endpoint = app.Flag("endpoint", "Base endpoint URI to scan instead of the provider's e.g. http://localhost:9000 for MinIO.").String()
+	configPtr.PathStyle = app.Flag("path-style", "Address buckets as endpoint/bucket instead of bucket.endpoint.").Bool()
+	configPtr.Insecure = app.Flag("insecure", "Skip TLS certificate verification e.g. for self-signed on-prem stores.").Bool()
 	configPtr.Download = app.Flag("download", "Download bucket content(s).").Bool()
 	configPtr.Output = app.Flag("output", "Download bucket content(s) destination directory. Defaults to current user's directory if none passed.").String()
-	configPtr.JSON = app.Flag("json", "Output results in JSON.").Bool()
+	configPtr.Downloads = newDownloadConfig(app)
+	configPtr.Proxies = newProxyConfig(app)
+	configPtr.JSON = app.Flag("json", "Output results in JSON, same as --format=json.").Bool()
+	configPtr.Format = app.Flag("format", "Output format: text, json, or ndjson, csv or tsv to write each result as it is scanned.").Default(TextFormat).Enum(TextFormat, JSONFormat, NDJSONFormat, CSVFormat, TSVFormat)
+	configPtr.Rows = app.Flag("rows", "Rows of the csv and tsv formats: bucket, or object for one row per listed object.").Default(BucketRows).Enum(BucketRows, ObjectRows)
+	configPtr.Tree = app.Flag("tree", "Output the directory tree of each bucket's files with per directory file counts and sizes.").Bool()
+	configPtr.TreeDepth = app.Flag("tree-depth", "Maximum directory depth of the --tree output. Defaults to all.").Int()
 	configPtr.Verbose = app.Flag("verbose", "Verbose output messages. Defaults to quiet.").Bool()
 
-	kingpin.MustParse(app.Parse(os.Args[1:]))
+	generateCmd := app.Command("generate", "Generate bucket name permutations from seed keywords and scan them.")
+	configPtr.Generate = newGenerateConfig(generateCmd)
+
+	command := kingpin.MustParse(app.Parse(os.Args[1:]))
 
 	// Default to all
 	if strings.Trim(*configPtr.CloudProvider, " ") == "" {
 		*configPtr.CloudProvider = All
 	}
 
-	if strings.Trim(*configPtr.Action, " ") == "" {
+	switch strings.ToLower(strings.Trim(*configPtr.Action, " ")) {
+	case "", All:
 		*configPtr.Action = All
+	case Read, "read":
+		*configPtr.Action = Read
+	case Write, "write":
+		*configPtr.Action = Write
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid scan action: %s\n", *configPtr.Action)
+		os.Exit(InvalidAction)
+	}
+
+	opts, err := getOptions()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(InvalidInput)
+	}
+	if streamed(*configPtr.Format) {
+		diagnostics = os.Stderr
+	}
+
+	scanners := getScanner(configPtr.CloudProvider, opts...)
+	if len(scanners) == 0 {
+		fmt.Fprintf(os.Stderr, "Invalid cloud provider: %s\n", *configPtr.CloudProvider)
+		os.Exit(InvalidCloud)
 	}
 
 	// output settings
+	configPtr.v(fmt.Sprintf("Command: %s", command))
 	configPtr.v(fmt.Sprintf("Cloud: %s", *configPtr.CloudProvider))
 	configPtr.v(fmt.Sprintf("Buckets: %s", *configPtr.BucketNames))
+	configPtr.v(fmt.Sprintf("Wordlists: %s", strings.Join(*configPtr.Wordlists, ", ")))
 	configPtr.v(fmt.Sprintf("Action: %s", *configPtr.Action))
 	configPtr.v(fmt.Sprintf("ThrottleMS: %d", *configPtr.ThrottleMs))
+	configPtr.v(fmt.Sprintf("RetryPasses: %d", *configPtr.RetryPasses))
+	configPtr.v(fmt.Sprintf("Timeout: %s", *configPtr.Timeout))
+	configPtr.v(fmt.Sprintf("Deadline: %s", *configPtr.Deadline))
+	configPtr.v(fmt.Sprintf("Workers: %d %v", *configPtr.Workers, *configPtr.ProviderWorkers))
+	configPtr.v(fmt.Sprintf("MaxObjects: %d", *configPtr.MaxObjects))
+	configPtr.v(fmt.Sprintf("Endpoint: %s", *configPtr.Endpoint))
+	configPtr.v(fmt.Sprintf("PathStyle: %t", *configPtr.PathStyle))
+	configPtr.v(fmt.Sprintf("Insecure: %t", *configPtr.Insecure))
+	configPtr.v(fmt.Sprintf("Proxies: %d Rotation: %s", len(*configPtr.Proxies.Proxies), *configPtr.Proxies.Rotation))
 	configPtr.v(fmt.Sprintf("Download: %t", *configPtr.Download))
 	configPtr.v(fmt.Sprintf("Output: %s", *configPtr.Output))
-	configPtr.v(fmt.Sprintf("JSON: %t", *configPtr.JSON))
+	configPtr.v(fmt.Sprintf("MaxObjectSize: %s", *configPtr.Downloads.MaxObjectSize))
+	configPtr.v(fmt.Sprintf("MaxTotalSize: %s", *configPtr.Downloads.MaxTotalSize))
+	configPtr.v(fmt.Sprintf("Include: %s Exclude: %s Ext: %s", strings.Join(*configPtr.Downloads.Include, ", "), strings.Join(*configPtr.Downloads.Exclude, ", "), strings.Join(*configPtr.Downloads.Extensions, ", ")))
+	configPtr.v(fmt.Sprintf("Modified: %s - %s", *configPtr.Downloads.ModifiedAfter, *configPtr.Downloads.ModifiedBefore))
+	configPtr.v(fmt.Sprintf("DryRun: %t", *configPtr.Downloads.DryRun))
+	configPtr.v(fmt.Sprintf("DownloadWorkers: %d Retries: %d", *configPtr.Downloads.Workers, *configPtr.Downloads.Retries))
+	configPtr.v(fmt.Sprintf("DownloadFormat: %s", *configPtr.Downloads.Format))
+	if *configPtr.JSON && *configPtr.Format == TextFormat {
+		*configPtr.Format = JSONFormat
+	}
+	configPtr.v(fmt.Sprintf("Format: %s Rows: %s", *configPtr.Format, *configPtr.Rows))
+	configPtr.v(fmt.Sprintf("Tree: %t Depth: %d", *configPtr.Tree, *configPtr.TreeDepth))
 	configPtr.v(fmt.Sprintf("Verbose: %t", *configPtr.Verbose))
 
-	// space or command delim
-	var bucketNames []string
-	if strings.Index(*configPtr.BucketNames, ",") > -1 {
-		bucketNames = strings.Split(*configPtr.BucketNames, ",")
-	} else {
-		bucketNames = strings.Split(*configPtr.BucketNames, " ")
+	var downloads *bucketscanner.DownloadOptions
+	if *configPtr.Download || len(*configPtr.Output) > 0 || *configPtr.Downloads.DryRun {
+		opts, err := configPtr.Downloads.options()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(InvalidInput)
+		}
+		downloads = &opts
 	}
 
-	var wg sync.WaitGroup
-	var mutex = &sync.Mutex{}
-	var buckets = []*bucketscanner.Bucket{}
-
-	scanners := getScanner(configPtr.CloudProvider)
-
-	for idx, scanner := range scanners {
-		wg.Add(idx + 1)
-
-		go func(scanner bucketscanner.Scanner) {
-			defer wg.Done()
-
-			for idx, bucketName := range bucketNames {
-				bucketName = strings.Trim(bucketName, " ")
-
-				if idx > 0 && *configPtr.ThrottleMs > 0 {
-					configPtr.v(fmt.Sprintf("Throttle via sleep for %d MS", *configPtr.ThrottleMs))
-					time.Sleep(time.Duration(*configPtr.ThrottleMs) * time.Millisecond)
-				}
+	// Ctrl-C cancels in flight requests and outputs the partial results, a second exits immediately
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if *configPtr.Deadline > 0 {
+		var cancelDeadline context.CancelFunc
+		ctx, cancelDeadline = context.WithTimeout(ctx, *configPtr.Deadline)
+		defer cancelDeadline()
+	}
 
-				configPtr.v(fmt.Sprintf("Getting from %s bucket: %s", scanner.GetProviderName(), bucketName))
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		configPtr.v("*** Scan Interrupted ****")
+		signal.Stop(signals)
+		cancel()
+	}()
 
-				// TODO account for read, write or both - configPtr.Action
-				bucket, err := scanner.Read(bucketName)
+	var names <-chan string
+	var namesErr <-chan error
+	var validate bool
 
-				if err != nil {
-					fmt.Println(err)
-				} else {
-					configPtr.v("Bucket response received")
-					mutex.Lock()
-					buckets = append(buckets, bucket)
-					mutex.Unlock()
-				}
+	switch command {
+	case generateCmd.FullCommand():
+		names = configPtr.Generate.generator().GenerateContext(ctx)
+		validate = true
 
-				if *configPtr.Download || (configPtr.Output != nil && len(*configPtr.Output) > 0) {
-					configPtr.v(fmt.Sprintf("Download bucket contents from %s ", bucketName))
-					zipFile, err := bucket.Download(*configPtr.Output)
-					if err != nil {
-						configPtr.v(fmt.Sprintf("Unable to download bucket due to error: %s", err.Error()))
-						//configPtr.v(fmt.Fprintln(os.Stderr, "Unable to download bucket due to error: %s", err.Error()))
-					}
-					fmt.Printf("Bucket downloaded successfully to %s\n", *zipFile)
-				}
-			}
-		}(scanner)
+		if *configPtr.Generate.List {
+			listNames(scanners, names)
+			cancel()
+			os.Exit(Success)
+		}
+	default:
+		if strings.Trim(*configPtr.BucketNames, " ") == "" && len(*configPtr.Wordlists) == 0 {
+			fmt.Fprintln(os.Stderr, "No bucket names or wordlists passed to scan")
+			cancel()
+			os.Exit(InvalidInput)
+		}
+		names, namesErr = inputNames(*configPtr.BucketNames, *configPtr.Wordlists)
 	}
 
-	wg.Wait()
+	stream := newReportWriter(*configPtr.Format, os.Stdout, *configPtr.Tree, *configPtr.Rows)
+	buckets, status := scan(ctx, scanners, names, validate, downloads, stream)
+	if stream != nil {
+		if err := stream.flush(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		fmt.Fprintln(os.Stderr, "Scan deadline exceeded, outputting partial results")
+	}
+	if ctx.Err() != nil {
+		status = Incomplete
+	}
+	select {
+	case err := <-namesErr:
+		// e.g. a mistyped wordlist path
+		fmt.Fprintln(os.Stderr, err)
+		status = InvalidInput
+	default:
+	}
 	configPtr.v("*** Scan Completed ****")
+	if *configPtr.Verbose {
+		configPtr.Proxies.printHealth(diagnostics)
+	}
 
 	// Output Results
 	for _, bucket := range buckets {
-		if *configPtr.JSON {
+		if *configPtr.Tree && *configPtr.Format == JSONFormat {
+			JSONStr, err := json.Marshal(newTreeRecord(bucket))
+			if err != nil {
+				fmt.Println(err)
+			}
+			fmt.Printf("%s\n", string(JSONStr))
+		} else if *configPtr.Tree {
+			printTree(os.Stdout, bucket, *configPtr.TreeDepth)
+		} else if *configPtr.Format == JSONFormat {
 			JSONStr, err := json.Marshal(bucket)
 			if err != nil {
 				fmt.Println(err)
@@ -183,5 +527,7 @@ func main() {
 		}
 	}
 
-	os.Exit(Success)
+	// os.Exit skips the deferred cancels, cancelling the scan context also stops its deadline
+	cancel()
+	os.Exit(status)
 }
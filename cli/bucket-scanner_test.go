@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"gitlab.com/cjbarker/bucketscanner"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// cliEnv is set when the test binary runs the command line with the arguments after --
+const cliEnv = "BUCKETSCANNER_CLI"
+
+// runCLI runs the command line with the arguments in a child process of the test binary,
+// returning its stdout, stderr and exit error
+func runCLI(t *testing.T, args ...string) (stdout string, stderr string, err error) {
+	cmd := exec.Command(os.Args[0], append([]string{"-test.run=^" + t.Name() + "$", "--"}, args...)...)
+	cmd.Env = append(os.Environ(), cliEnv+"=1")
+
+	var out, errOut bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &errOut
+	err = cmd.Run()
+	return out.String(), errOut.String(), err
+}
+
+// newListingServer starts an S3 compatible store serving a public listing bucket
+func newListingServer(t *testing.T) *httptest.Server {
+	objects := map[string]int{"a,b.txt": 4, "dir/=formula.csv": 10}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/listing" {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, "<Error><Code>NoSuchBucket</Code></Error>")
+			return
+		}
+		if r.Method == http.MethodHead {
+			return
+		}
+
+		var result bucketscanner.ListBucketResult
+		for key, size := range objects {
+			result.ContentsList = append(result.ContentsList, bucketscanner.Contents{
+				Key:          key,
+				Size:         size,
+				Etag:         `"d41d8cd98f00b204e9800998ecf8427e"`,
+				LastModified: "2018-04-11T18:31:16.000Z",
+				StorageClass: "STANDARD",
+			})
+		}
+		xml.NewEncoder(w).Encode(result)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestCSVVerboseDryRun(t *testing.T) {
+	if os.Getenv(cliEnv) != "" {
+		os.Args = append([]string{os.Args[0]}, flag.Args()...)
+		main()
+		return
+	}
+
+	server := newListingServer(t)
+	stdout, stderr, err := runCLI(t, "--cloud=aws", "--action=r", "--format=csv", "--verbose", "--dry-run",
+		"--endpoint="+server.URL, "--path-style", "listing")
+	if err != nil {
+		t.Fatalf("Unexpected exit error: %s\n%s", err.Error(), stderr)
+	}
+
+	// stdout is only the report, the verbose and dry run messages are written to stderr
+	records, err := csv.NewReader(strings.NewReader(stdout)).ReadAll()
+	if err != nil {
+		t.Fatalf("Unable to parse CSV output due to error: %s\n%s", err.Error(), stdout)
+	}
+	if len(records) != 2 || strings.Join(records[0], ",") != strings.Join(bucketColumns, ",") {
+		t.Fatalf("CSV output should be a header and one bucket row, got: %v", records)
+	}
+	if row := records[1]; row[1] != "listing" || row[3] != "public" || row[5] != "2" || row[6] != "14" ||
+		row[9] != "2" || row[10] != "0" {
+		t.Errorf("CSV bucket row error, got: %v", row)
+	}
+
+	for _, msg := range []string{"Verbose: true", "Would download " + server.URL + "/listing/a,b.txt", "Dry run of bucket listing"} {
+		if !strings.Contains(stderr, msg) {
+			t.Errorf("Message %q should be written to stderr, got: %s", msg, stderr)
+		}
+	}
+}
+
+func TestUnsupportedWriteQuiet(t *testing.T) {
+	if os.Getenv(cliEnv) != "" {
+		os.Args = append([]string{os.Args[0]}, flag.Args()...)
+		main()
+		return
+	}
+
+	// GCS has no write action so its unsupported error is only printed when verbose
+	server := newListingServer(t)
+	stdout, stderr, err := runCLI(t, "--cloud=gcp", "--action=all", "--format=csv", "--endpoint="+server.URL, "--path-style", "listing")
+	if err != nil || stderr != "" {
+		t.Errorf("Unsupported write of a read bucket should not fail the scan, got: %v\n%s", err, stderr)
+	}
+	if !strings.Contains(stdout, "listing") {
+		t.Errorf("Read bucket should be output, got: %s", stdout)
+	}
+
+	_, stderr, err = runCLI(t, "--cloud=gcp", "--action=all", "--format=csv", "--verbose", "--endpoint="+server.URL, "--path-style", "listing")
+	if err != nil || !strings.Contains(stderr, "Writer is currently not supported") {
+		t.Errorf("Unsupported write should be printed when verbose, got: %v\n%s", err, stderr)
+	}
+}
+
+func TestNDJSONTree(t *testing.T) {
+	if os.Getenv(cliEnv) != "" {
+		os.Args = append([]string{os.Args[0]}, flag.Args()...)
+		main()
+		return
+	}
+
+	server := newListingServer(t)
+	stdout, stderr, err := runCLI(t, "--cloud=aws", "--action=r", "--format=ndjson", "--tree", "--endpoint="+server.URL, "--path-style", "listing")
+	if err != nil {
+		t.Fatalf("Unexpected exit error: %s\n%s", err.Error(), stderr)
+	}
+
+	// the tree record names its bucket as the root directory has a blank name
+	var record struct {
+		Provider string
+		Name     string
+		URI      string
+		State    bucketscanner.BucketState
+		Tree     bucketscanner.File
+	}
+	if err = json.Unmarshal([]byte(stdout), &record); err != nil {
+		t.Fatalf("Unable to parse NDJSON output due to error: %s\n%s", err.Error(), stdout)
+	}
+	if record.Name != "listing" || record.URI != server.URL+"/listing" || record.Provider == "" || record.State != bucketscanner.Public {
+		t.Errorf("Tree record should identify its bucket, got: %+v", record)
+	}
+	if !record.Tree.IsDir || record.Tree.NoFiles != 2 || len(record.Tree.Files) != 2 {
+		t.Errorf("Tree record root error, got: %+v", record.Tree)
+	}
+}
+
+func TestUnreadableWordlist(t *testing.T) {
+	if os.Getenv(cliEnv) != "" {
+		os.Args = append([]string{os.Args[0]}, flag.Args()...)
+		main()
+		return
+	}
+
+	server := newListingServer(t)
+	_, stderr, err := runCLI(t, "--cloud=aws", "--action=r", "--endpoint="+server.URL, "--path-style", "--wordlist=missing-names.txt")
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != InvalidInput || !strings.Contains(stderr, "missing-names.txt") {
+		t.Errorf("Unreadable wordlist should exit with %d, got: %v\n%s", InvalidInput, err, stderr)
+	}
+}
+
+func TestInvalidNameExit(t *testing.T) {
+	if os.Getenv(cliEnv) != "" {
+		os.Args = append([]string{os.Args[0]}, flag.Args()...)
+		main()
+		return
+	}
+
+	// a name breaking the naming rules is rejected before any request could reach another host
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("Invalid name should not be requested, got: %s", r.URL)
+	}))
+	t.Cleanup(server.Close)
+
+	_, stderr, err := runCLI(t, "--cloud=aws", "--action=r", "--endpoint="+server.URL, "--path-style", "evil.example/listing")
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != InvalidName || !strings.Contains(stderr, "naming rules") {
+		t.Errorf("Invalid name should exit with %d, got: %v\n%s", InvalidName, err, stderr)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/alecthomas/units"
+	"gitlab.com/cjbarker/bucketscanner"
+	"gopkg.in/alecthomas/kingpin.v2"
+	"os"
+	"strconv"
+	"time"
+)
+
+// DownloadConfig is struct representing the download selection and limit settings
+type DownloadConfig struct {
+	MaxObjectSize  *units.Base2Bytes
+	MaxTotalSize   *units.Base2Bytes
+	Include        *[]string
+	Exclude        *[]string
+	Extensions     *[]string
+	ModifiedAfter  *string
+	ModifiedBefore *string
+	DryRun         *bool
+	Workers        *int
+	Retries        *int
+	Format         *string
+}
+
+// newDownloadConfig registers the download flags
+func newDownloadConfig(app *kingpin.Application) *DownloadConfig {
+	return &DownloadConfig{
+		MaxObjectSize:  app.Flag("max-object-size", "Skip downloading objects larger than this size e.g. 100MB. Defaults to unlimited.").Bytes(),
+		MaxTotalSize:   app.Flag("max-total-size", "Skip objects that would take a bucket's download over this total size, still downloading smaller ones e.g. 2GB. Defaults to unlimited.").Bytes(),
+		Include:        app.Flag("include", "Key glob pattern of objects to download e.g. '*.sql' or 'backups/*'. Repeatable, defaults to all.").Strings(),
+		Exclude:        app.Flag("exclude", "Key glob pattern of objects not to download. Repeatable.").Strings(),
+		Extensions:     app.Flag("ext", "Extension of objects to download e.g. csv. Repeatable, defaults to all.").Strings(),
+		ModifiedAfter:  app.Flag("modified-after", "Only download objects last modified after this date e.g. 2018-01-31 or RFC3339 time.").String(),
+		ModifiedBefore: app.Flag("modified-before", "Only download objects last modified before this date e.g. 2018-01-31 or RFC3339 time.").String(),
+		DryRun:         app.Flag("dry-run", "Print the objects that would be downloaded and their total size without downloading.").Bool(),
+		Workers:        app.Flag("download-workers", "Number of concurrent object downloads per bucket.").Default(strconv.Itoa(bucketscanner.DefaultDownloadWorkers)).Int(),
+		Retries:        app.Flag("download-retries", "Number of retries of a failed object download, -1 for none.").Default(strconv.Itoa(bucketscanner.DefaultDownloadRetries)).Int(),
+		Format:         app.Flag("download-format", "Download output format: zip, tar.gz or dir to mirror the object keys in a directory.").Default(bucketscanner.ZipFormat).Enum(bucketscanner.ZipFormat, bucketscanner.TarGzFormat, bucketscanner.DirFormat),
+	}
+}
+
+// parseDate parses a YYYY-MM-DD date or RFC3339 time, returning the zero time for a blank string
+func parseDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if date, err := time.Parse("2006-01-02", value); err == nil {
+		return date, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// options returns the library download options of the download settings
+func (c DownloadConfig) options() (opts bucketscanner.DownloadOptions, err error) {
+	opts = bucketscanner.DownloadOptions{
+		MaxObjectSize: int64(*c.MaxObjectSize),
+		MaxTotalSize:  int64(*c.MaxTotalSize),
+		Include:       *c.Include,
+		Exclude:       *c.Exclude,
+		Extensions:    *c.Extensions,
+		DryRun:        *c.DryRun,
+		Workers:       *c.Workers,
+		Retries:       *c.Retries,
+		Format:        *c.Format,
+	}
+
+	if opts.ModifiedAfter, err = parseDate(*c.ModifiedAfter); err != nil {
+		return opts, fmt.Errorf("invalid --modified-after date '%s'", *c.ModifiedAfter)
+	}
+	if opts.ModifiedBefore, err = parseDate(*c.ModifiedBefore); err != nil {
+		return opts, fmt.Errorf("invalid --modified-before date '%s'", *c.ModifiedBefore)
+	}
+
+	return opts, nil
+}
+
+// download downloads the selected bucket contents to the output directory, or prints the
+// selection on a dry run to the diagnostics, returning the report, if any, and exit status.
+// Incomplete downloads print the manifest kept to resume them. Buckets without files to download
+// are not a failure.
+func download(ctx context.Context, bucket *bucketscanner.Bucket, opts bucketscanner.DownloadOptions) (*bucketscanner.DownloadReport, int) {
+	configPtr.v(fmt.Sprintf("Download bucket contents from %s ", bucket.Name))
+
+	report, err := bucket.DownloadWithOptions(ctx, *configPtr.Output, opts)
+	if report != nil && report.Manifest != "" {
+		defer fmt.Fprintf(diagnostics, "Bucket %s download incomplete, %d file(s) failed. Rerun to resume from %s\n", bucket.Name, len(report.Failed), report.Manifest)
+	}
+	if errors.Is(err, bucketscanner.ErrNoFiles) {
+		configPtr.v(fmt.Sprintf("Unable to download bucket due to error: %s", err.Error()))
+		return report, Success
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to download bucket due to error: %s\n", err.Error())
+		return report, DownloadFailure
+	}
+
+	if report.DryRun {
+		for _, name := range report.Downloaded {
+			fmt.Fprintf(diagnostics, "Would download %s/%s\n", bucket.URI, name)
+		}
+		fmt.Fprintf(diagnostics, "Dry run of bucket %s: %d file(s) totalling %s would be downloaded\n", bucket.Name, len(report.Downloaded), humanSize(report.Bytes))
+	} else {
+		fmt.Fprintf(diagnostics, "Bucket downloaded successfully to %s\n", report.Path)
+	}
+
+	for _, name := range report.Mismatched {
+		fmt.Fprintf(diagnostics, "Downloaded %s/%s does not match its listed ETag\n", bucket.URI, name)
+	}
+	if len(report.Resumed) > 0 {
+		configPtr.v(fmt.Sprintf("Resumed %d file(s) downloaded previously", len(report.Resumed)))
+	}
+	for _, name := range report.Failed {
+		configPtr.v(fmt.Sprintf("Failed to download %s", name))
+	}
+	for _, name := range report.Rejected {
+		configPtr.v(fmt.Sprintf("Rejected unsafe file key %q", name))
+	}
+	if len(report.Skipped) > 0 {
+		configPtr.v(fmt.Sprintf("Skipped %d file(s) over the download size limits", len(report.Skipped)))
+	}
+	if len(report.Excluded) > 0 {
+		configPtr.v(fmt.Sprintf("Excluded %d file(s) by the download filters", len(report.Excluded)))
+	}
+
+	if len(report.Failed) > 0 {
+		return report, DownloadFailure
+	}
+	return report, Success
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"gitlab.com/cjbarker/bucketscanner"
+	"gitlab.com/cjbarker/bucketscanner/generator"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// GenerateConfig is struct representing the generate command argument settings
+type GenerateConfig struct {
+	Keywords     *[]string
+	Prefixes     *[]string
+	Suffixes     *[]string
+	Environments *[]string
+	Separators   *[]string
+	Years        *[]int
+	List         *bool
+}
+
+// newGenerateConfig registers the generate command arguments and flags
+func newGenerateConfig(cmd *kingpin.CmdClause) *GenerateConfig {
+	return &GenerateConfig{
+		Keywords:     cmd.Arg("keyword", "Seed keyword(s) to expand e.g. company, product or domain.").Required().Strings(),
+		Prefixes:     cmd.Flag("prefix", "Prefix word to prepend to keywords. Repeatable, replaces the defaults.").Strings(),
+		Suffixes:     cmd.Flag("suffix", "Suffix word to append to keywords. Repeatable, replaces the defaults.").Strings(),
+		Environments: cmd.Flag("env", "Environment word e.g. dev, prod. Repeatable, replaces the defaults.").Strings(),
+		Separators:   cmd.Flag("separator", "Separator placed between words. Repeatable, replaces the defaults.").Strings(),
+		Years:        cmd.Flag("year", "Year to append to keywords. Repeatable, defaults to the last three years.").Ints(),
+		List:         cmd.Flag("list", "List the generated bucket names without scanning them.").Bool(),
+	}
+}
+
+// generator returns the bucket name generator for the configured keywords and word lists
+func (c GenerateConfig) generator() *generator.Generator {
+	gen := generator.New(*c.Keywords...)
+
+	if len(*c.Prefixes) > 0 {
+		gen.Prefixes = *c.Prefixes
+	}
+	if len(*c.Suffixes) > 0 {
+		gen.Suffixes = *c.Suffixes
+	}
+	if len(*c.Environments) > 0 {
+		gen.Environments = *c.Environments
+	}
+	if len(*c.Separators) > 0 {
+		gen.Separators = *c.Separators
+	}
+	if len(*c.Years) > 0 {
+		gen.Years = *c.Years
+	}
+
+	return gen
+}
+
+// getValidator returns the bucket naming rules of the scanner's cloud provider
+func getValidator(scanner bucketscanner.Scanner) generator.Validator {
+	switch scanner.(type) {
+	case *bucketscanner.AwsScanner:
+		return generator.ValidAwsName
+	case *bucketscanner.GcpScanner:
+		return generator.ValidGcpName
+	case *bucketscanner.AzureScanner:
+		return generator.ValidAzureName
+	}
+
+	return func(name string) bool {
+		return true
+	}
+}
+
+// listNames prints the names valid for at least one of the scanners
+func listNames(scanners []bucketscanner.Scanner, names <-chan string) {
+	for name := range names {
+		for _, scanner := range scanners {
+			if getValidator(scanner)(name) {
+				fmt.Println(name)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"gitlab.com/cjbarker/bucketscanner"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Output formats of the scan results
+const (
+	TextFormat   = "text"
+	JSONFormat   = "json"
+	NDJSONFormat = "ndjson" // One JSON record per line written as each bucket is scanned
+	CSVFormat    = "csv"
+	TSVFormat    = "tsv"
+)
+
+// Rows of the CSV and TSV formats
+const (
+	BucketRows = "bucket"
+	ObjectRows = "object" // One row per listed object of each bucket
+)
+
+// Columns of the CSV and TSV formats
+var (
+	bucketColumns = []string{"provider", "name", "uri", "state", "region", "noFiles", "totalSize", "writable", "scanned", "downloaded", "mismatched"}
+	objectColumns = []string{"provider", "bucket", "name", "directory", "size", "lastModified", "etag", "storageClass", "owner", "contentType", "mismatched"}
+)
+
+// streamed reports whether the results of the output format are streamed as each bucket is scanned
+func streamed(format string) bool {
+	return format == NDJSONFormat || format == CSVFormat || format == TSVFormat
+}
+
+// reportWriter streams the bucket results of a scan as each is scanned
+type reportWriter interface {
+	write(bucket *bucketscanner.Bucket) error
+	flush() error
+}
+
+// newReportWriter returns the streaming writer of the output format, or else nil when the results
+// are output once the scan completes. The rows are of the CSV and TSV formats.
+func newReportWriter(format string, w io.Writer, tree bool, rows string) reportWriter {
+	switch format {
+	case NDJSONFormat:
+		return newNDJSONWriter(w, tree)
+	case CSVFormat:
+		return newCSVWriter(w, ',', rows == ObjectRows)
+	case TSVFormat:
+		return newCSVWriter(w, '\t', rows == ObjectRows)
+	}
+	return nil
+}
+
+// treeRecord is the JSON record of a bucket's directory tree, identifying its bucket since the
+// root directory of the tree has a blank name
+type treeRecord struct {
+	Provider string                        `json:"provider"`
+	Name     string                        `json:"name"`
+	URI      string                        `json:"uri"`
+	State    bucketscanner.BucketState     `json:"state"`
+	Tree     *bucketscanner.File           `json:"tree"`
+	Download *bucketscanner.DownloadReport `json:"download,omitempty"`
+}
+
+// newTreeRecord returns the tree record of the bucket
+func newTreeRecord(bucket *bucketscanner.Bucket) treeRecord {
+	return treeRecord{
+		Provider: bucket.Provider,
+		Name:     bucket.Name,
+		URI:      bucket.URI,
+		State:    bucket.State,
+		Tree:     bucket.Tree(),
+		Download: bucket.DownloadReport,
+	}
+}
+
+// ndjsonWriter writes each bucket, or its directory tree, as a JSON record per line. Every record
+// is written whole and flushed so concurrent writes never interleave and an interrupted scan keeps
+// the records already written.
+type ndjsonWriter struct {
+	mutex   sync.Mutex
+	writer  *bufio.Writer
+	encoder *json.Encoder
+	tree    bool
+}
+
+// newNDJSONWriter returns an NDJSON writer of the buckets to the writer
+func newNDJSONWriter(w io.Writer, tree bool) *ndjsonWriter {
+	writer := bufio.NewWriter(w)
+	return &ndjsonWriter{writer: writer, encoder: json.NewEncoder(writer), tree: tree}
+}
+
+func (n *ndjsonWriter) write(bucket *bucketscanner.Bucket) error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	var record interface{} = bucket
+	if n.tree {
+		record = newTreeRecord(bucket)
+	}
+	if err := n.encoder.Encode(record); err != nil {
+		return err
+	}
+	return n.writer.Flush()
+}
+
+func (n *ndjsonWriter) flush() error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	return n.writer.Flush()
+}
+
+// csvWriter writes a header then a row per bucket, or per object of each bucket, delimited by
+// commas or tabs. The rows of a bucket are written together and flushed as with ndjsonWriter.
+type csvWriter struct {
+	mutex   sync.Mutex
+	writer  *csv.Writer
+	objects bool
+	header  bool
+}
+
+// newCSVWriter returns a writer of the bucket, or else object, rows delimited by the comma
+func newCSVWriter(w io.Writer, comma rune, objects bool) *csvWriter {
+	writer := csv.NewWriter(w)
+	writer.Comma = comma
+	return &csvWriter{writer: writer, objects: objects}
+}
+
+// writeHeader writes the column names once before the first row
+func (c *csvWriter) writeHeader() error {
+	if c.header {
+		return nil
+	}
+	c.header = true
+
+	if c.objects {
+		return c.writer.Write(objectColumns)
+	}
+	return c.writer.Write(bucketColumns)
+}
+
+func (c *csvWriter) write(bucket *bucketscanner.Bucket) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if err := c.writeHeader(); err != nil {
+		return err
+	}
+
+	if c.objects {
+		for idx := range bucket.Files {
+			if err := c.writer.Write(objectRow(bucket, &bucket.Files[idx])); err != nil {
+				return err
+			}
+		}
+	} else if err := c.writer.Write(bucketRow(bucket)); err != nil {
+		return err
+	}
+
+	c.writer.Flush()
+	return c.writer.Error()
+}
+
+// flush writes the header when no bucket was written so the output is still a valid table
+func (c *csvWriter) flush() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if err := c.writeHeader(); err != nil {
+		return err
+	}
+	c.writer.Flush()
+	return c.writer.Error()
+}
+
+// bucketRow returns the columns of the bucket. The downloaded and mismatched object counts are
+// blank when the bucket was not downloaded.
+func bucketRow(bucket *bucketscanner.Bucket) []string {
+	var downloaded, mismatched string
+	if report := bucket.DownloadReport; report != nil {
+		downloaded = strconv.Itoa(len(report.Downloaded))
+		mismatched = strconv.Itoa(len(report.Mismatched))
+	}
+
+	return []string{
+		bucket.Provider,
+		cell(bucket.Name),
+		cell(bucket.URI),
+		bucket.State.String(),
+		bucket.Region,
+		strconv.FormatInt(bucket.NoFiles, 10),
+		strconv.FormatInt(bucket.TotalSize, 10),
+		strconv.FormatBool(bucket.Writable),
+		timeCell(bucket.Scanned),
+		downloaded,
+		mismatched,
+	}
+}
+
+// objectRow returns the columns of the bucket object
+func objectRow(bucket *bucketscanner.Bucket, bucketFile *bucketscanner.File) []string {
+	return []string{
+		bucket.Provider,
+		cell(bucket.Name),
+		cell(bucketFile.Name),
+		strconv.FormatBool(bucketFile.IsDir),
+		strconv.FormatInt(bucketFile.Size, 10),
+		timeCell(bucketFile.LastModified),
+		bucketFile.ETag,
+		bucketFile.StorageClass,
+		cell(bucketFile.Owner),
+		cell(bucketFile.ContentType),
+		mismatchedCell(bucket.DownloadReport, bucketFile.Name),
+	}
+}
+
+// mismatchedCell returns whether the downloaded object does not match its listed ETag, or else
+// blank when it was not downloaded
+func mismatchedCell(report *bucketscanner.DownloadReport, key string) string {
+	if report == nil {
+		return ""
+	}
+	for _, downloaded := range report.Downloaded {
+		if downloaded == key {
+			for _, mismatched := range report.Mismatched {
+				if mismatched == key {
+					return "true"
+				}
+			}
+			return "false"
+		}
+	}
+	return ""
+}
+
+// timeCell returns the RFC3339 UTC time, or else blank for the zero time
+func timeCell(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// cell returns a value listed by the bucket owner, escaping a leading =, +, -, @, tab or carriage
+// return with an apostrophe so spreadsheets never evaluate it as a formula
+func cell(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"gitlab.com/cjbarker/bucketscanner"
+	"io"
+	"path"
+	"strings"
+)
+
+// printTree renders the bucket's directory tree with the file count, aggregate size and share of
+// the bucket's size of each directory, descending at most depth levels when above zero
+func printTree(w io.Writer, bucket *bucketscanner.Bucket, depth int) {
+	tree := bucket.Tree()
+	fmt.Fprintf(w, "%s %s\n", bucket.URI, dirSummary(*tree, tree.Size))
+	printDir(w, *tree, tree.Size, "", depth)
+}
+
+// dirSummary returns the file count, size and share of the total of the directory
+func dirSummary(dir bucketscanner.File, total int64) string {
+	share := 100.0
+	if total > 0 {
+		share = float64(dir.Size) * 100 / float64(total)
+	}
+	return fmt.Sprintf("(%d files, %s, %.1f%%)", dir.NoFiles, humanSize(dir.Size), share)
+}
+
+// printDir renders the contents of the directory beneath the given line prefix
+func printDir(w io.Writer, dir bucketscanner.File, total int64, prefix string, depth int) {
+	for idx, child := range dir.Files {
+		branch, indent := "├── ", "│   "
+		if idx == len(dir.Files)-1 {
+			branch, indent = "└── ", "    "
+		}
+
+		if !child.IsDir {
+			fmt.Fprintf(w, "%s%s%s (%s)\n", prefix, branch, path.Base(child.Name), humanSize(child.Size))
+			continue
+		}
+
+		fmt.Fprintf(w, "%s%s%s/ %s\n", prefix, branch, path.Base(strings.TrimSuffix(child.Name, "/")), dirSummary(child, total))
+		if depth != 1 {
+			printDir(w, child, total, prefix+indent, depth-1)
+		}
+	}
+}
+
+// humanSize returns the byte size in the largest whole binary unit e.g. 1.5MiB
+func humanSize(size int64) string {
+	if size < 1024 {
+		return fmt.Sprintf("%dB", size)
+	}
+
+	value := float64(size)
+	unit := -1
+	for value >= 1024 && unit < 5 {
+		value /= 1024
+		unit++
+	}
+	return fmt.Sprintf("%.1f%ciB", value, "KMGTPE"[unit])
+}
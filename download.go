@@ -0,0 +1,517 @@
+package bucketscanner
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Download defaults
+const (
+	DefaultDownloadWorkers = 4
+	DefaultDownloadRetries = 3
+	DefaultDownloadBackoff = 500 * time.Millisecond
+	maxDownloadBackoff     = 10 * time.Second
+)
+
+// DownloadOptions select the objects, limit the bytes and tune the fetching of
+// Bucket.DownloadWithOptions
+type DownloadOptions struct {
+	MaxObjectSize  int64         // Objects larger than this many bytes are skipped; zero or less is unlimited
+	MaxTotalSize   int64         // Objects that would exceed this many bytes in total are skipped; zero or less is unlimited
+	Include        []string      // Key glob patterns of objects to download e.g. *.sql; empty includes all
+	Exclude        []string      // Key glob patterns of objects not to download, applied after Include
+	Extensions     []string      // Key extensions of objects to download e.g. .csv or csv; empty includes all
+	ModifiedAfter  time.Time     // Objects last modified before this time are excluded; zero is unbounded
+	ModifiedBefore time.Time     // Objects last modified after this time are excluded; zero is unbounded
+	DryRun         bool          // Report the objects that would be downloaded without fetching them
+	Workers        int           // Concurrent object fetches; zero or less uses DefaultDownloadWorkers
+	Retries        int           // Refetches of a failed object; zero uses DefaultDownloadRetries, less than zero none
+	Backoff        time.Duration // Delay before the first refetch, doubling each time; zero or less uses DefaultDownloadBackoff
+	Format         string        // Output format of ZipFormat, TarGzFormat or DirFormat; blank uses ZipFormat
+}
+
+// DownloadReport summarises the objects written by a bucket download. On a dry run Downloaded
+// and Bytes are the objects and listed bytes that would have been downloaded.
+type DownloadReport struct {
+	Path       string   `json:"path,omitempty"`     // Archive file or mirror directory
+	Manifest   string   `json:"manifest,omitempty"` // Manifest kept to resume an incomplete download
+	DryRun     bool     `json:"dryRun"`
+	Downloaded []string `json:"downloaded"`
+	Resumed    []string `json:"resumed"`    // Downloaded objects completed by a previous download
+	Failed     []string `json:"failed"`     // Selected objects not downloaded due to errors or interruption
+	Mismatched []string `json:"mismatched"` // Downloaded objects whose content MD5 does not match their listed ETag
+	Skipped    []string `json:"skipped"`    // Selected objects over the size limits
+	Excluded   []string `json:"excluded"`   // Objects not matching the key, extension or date filters
+	Rejected   []string `json:"rejected"`   // Objects whose keys could escape the output or cannot be written at their paths
+	Bytes      int64    `json:"bytes"`
+}
+
+// pendingFile is an object selected for download with its byte limit
+type pendingFile struct {
+	file  *File
+	limit int64
+}
+
+// permanentError is a fetch failure that refetching will not fix e.g. access denied
+type permanentError struct {
+	error
+}
+
+// matchKey reports whether the object key matches the glob pattern. Patterns without a slash
+// also match the key's base name so *.sql matches backups/db.sql.
+func matchKey(pattern string, key string) bool {
+	if matched, _ := path.Match(pattern, key); matched {
+		return true
+	}
+	if !strings.Contains(pattern, "/") {
+		matched, _ := path.Match(pattern, path.Base(key))
+		return matched
+	}
+	return false
+}
+
+// selects reports whether the bucket file passes the key, extension and date filters
+func (o DownloadOptions) selects(bucketFile *File) bool {
+	if len(o.Include) > 0 {
+		included := false
+		for _, pattern := range o.Include {
+			if matchKey(pattern, bucketFile.Name) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return false
+		}
+	}
+
+	for _, pattern := range o.Exclude {
+		if matchKey(pattern, bucketFile.Name) {
+			return false
+		}
+	}
+
+	if len(o.Extensions) > 0 {
+		ext := strings.ToLower(path.Ext(bucketFile.Name))
+		included := false
+		for _, extension := range o.Extensions {
+			extension = strings.ToLower(strings.Trim(extension, " "))
+			if !strings.HasPrefix(extension, ".") {
+				extension = "." + extension
+			}
+			if ext == extension {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return false
+		}
+	}
+
+	// objects without a listed modification time cannot be within a window
+	if !o.ModifiedAfter.IsZero() && !bucketFile.LastModified.After(o.ModifiedAfter) {
+		return false
+	}
+	if !o.ModifiedBefore.IsZero() && !bucketFile.LastModified.Before(o.ModifiedBefore) {
+		return false
+	}
+
+	return true
+}
+
+// stagedPath returns the path the object is downloaded to within the staging directory. Keys
+// are hashed so they cannot escape the directory.
+func stagedPath(objectsDir string, key string) string {
+	sum := md5.Sum([]byte(key))
+	return filepath.Join(objectsDir, hex.EncodeToString(sum[:]))
+}
+
+// stagingPath returns the directory staging the download of the bucket within the destination
+// directory, named by its provider, name and a hash of its URI so a bucket of the same name on
+// another provider or endpoint never resumes the download
+func stagingPath(destDir string, b Bucket) string {
+	sum := md5.Sum([]byte(b.URI))
+	return destDir + "bucket-" + safeName(b.Provider) + "-" + safeName(b.Name) + "-" + hex.EncodeToString(sum[:4]) + ".partial"
+}
+
+// objectURI returns the URI of the object key within the bucket URI, escaping each segment of the
+// key so characters such as ?, #, % and spaces address the object rather than the query
+func objectURI(bucketURI string, key string) string {
+	segments := strings.Split(key, "/")
+	for idx, segment := range segments {
+		segments[idx] = url.PathEscape(segment)
+	}
+	return bucketURI + "/" + strings.Join(segments, "/")
+}
+
+// stallReader reads a response body restarting the stall timer after each read
+type stallReader struct {
+	io.Reader
+	timer *time.Timer
+	stall time.Duration
+}
+
+func (r stallReader) Read(p []byte) (n int, err error) {
+	n, err = r.Reader.Read(p)
+	r.timer.Reset(r.stall)
+	return n, err
+}
+
+// fetchObject streams the HTTP bucket file download to the staged path returning its MD5
+// checksum, computed as it is written, failing once more than limit bytes are read when limit is above zero.
+// The fetch is cancelled once the response headers, or the next bytes of the body, take longer than stall.
+func (b Bucket) fetchObject(ctx context.Context, client *http.Client, stall time.Duration, bucketFile *File, staged string, limit int64) (written int64, checksum string, err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var stalled int32
+	timer := time.AfterFunc(stall, func() {
+		atomic.StoreInt32(&stalled, 1)
+		cancel()
+	})
+	defer timer.Stop()
+	defer func() {
+		if err != nil && atomic.LoadInt32(&stalled) == 1 {
+			err = fmt.Errorf("Stalled for %s downloading %s: %w", stall, bucketFile.Name, err)
+		}
+	}()
+
+	resp, err := doRequest(ctx, client, http.MethodGet, objectURI(b.URI, bucketFile.Name), nil)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	timer.Reset(stall)
+
+	if resp.StatusCode != 200 {
+		err = &StatusError{URI: resp.Request.URL.String(), StatusCode: resp.StatusCode, Status: resp.Status}
+		if resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			err = permanentError{err}
+		}
+		return 0, "", err
+	}
+
+	stagedFile, err := os.Create(staged)
+	if err != nil {
+		return 0, "", permanentError{err}
+	}
+	defer stagedFile.Close()
+
+	var body io.Reader = stallReader{Reader: resp.Body, timer: timer, stall: stall}
+	if limit > 0 {
+		body = io.LimitReader(body, limit+1)
+	}
+
+	hash := md5.New()
+	written, err = io.Copy(io.MultiWriter(stagedFile, hash), body)
+	if err != nil {
+		return written, "", fmt.Errorf("Failed to download file: %w", err)
+	}
+	if limit > 0 && written > limit {
+		return written, "", permanentError{errors.New("File exceeds download limit of " + strconv.FormatInt(limit, 10) + " bytes")}
+	}
+
+	return written, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// downloadObject fetches the bucket file to the staged path, refetching with an exponential
+// backoff on failure, and returns its manifest entry with the content verified against the ETag
+func (b Bucket) downloadObject(ctx context.Context, client *http.Client, stall time.Duration, pending pendingFile, staged string, opts DownloadOptions) ManifestEntry {
+	entry := ManifestEntry{Key: pending.file.Name, ETag: pending.file.ETag, Status: ObjectFailed}
+
+	// directories have no content to download
+	if pending.file.IsDir {
+		entry.Status = ObjectComplete
+		return entry
+	}
+
+	retries := opts.Retries
+	if retries == 0 {
+		retries = DefaultDownloadRetries
+	}
+	backoff := opts.Backoff
+	if backoff <= 0 {
+		backoff = DefaultDownloadBackoff
+	}
+
+	for {
+		entry.Attempts++
+		written, checksum, err := b.fetchObject(ctx, client, stall, pending.file, staged, pending.limit)
+		if err == nil {
+			entry.Status = ObjectComplete
+			entry.Size = written
+			entry.MD5 = checksum
+			entry.Verified = verifyETag(pending.file.ETag, checksum, staged, written)
+			entry.Error = ""
+			return entry
+		}
+
+		entry.Error = err.Error()
+		var permanent permanentError
+		if errors.As(err, &permanent) || entry.Attempts > retries || sleepContext(ctx, backoff) != nil {
+			return entry
+		}
+
+		if backoff *= 2; backoff > maxDownloadBackoff {
+			backoff = maxDownloadBackoff
+		}
+	}
+}
+
+// resumable reports whether the manifest records the bucket file as completed by a previous
+// download, unchanged since by its listed ETag, and its staged content is still present
+func resumable(manifest *Manifest, bucketFile *File, staged string) bool {
+	entry, ok := manifest.entry(bucketFile.Name)
+	if !ok || entry.Status != ObjectComplete || entry.ETag != bucketFile.ETag {
+		return false
+	}
+	if bucketFile.IsDir {
+		return true
+	}
+
+	fi, err := os.Stat(staged)
+	return err == nil && fi.Size() == entry.Size
+}
+
+// Download the contents of the bucket to a given destination directory
+func (b Bucket) Download(destDir string) (archivePath *string, err error) {
+	report, err := b.DownloadWithOptions(context.Background(), destDir, DownloadOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return &report.Path, nil
+}
+
+// destination validates the destination directory, defaulting to the user's home directory, and
+// returns it with a trailing path separator
+func destination(destDir string) (string, error) {
+
+	// set destination archive path to user's home dir
+	if strings.Trim(destDir, " ") == "" {
+		//return nil, errors.New("Destination directory is not accepted as a blank string")
+		usr, err := user.Current()
+		if err != nil {
+			return "", err
+		}
+		destDir = usr.HomeDir + string(os.PathSeparator)
+	}
+
+	fi, err := os.Lstat(destDir)
+	if os.IsNotExist(err) {
+		return "", fmt.Errorf("Destination file does NOT exist at %s: %w", destDir, ErrDestination)
+	}
+
+	mode := fi.Mode()
+	if !mode.IsDir() {
+		return "", fmt.Errorf("Destination file is NOT a directory %s: %w", destDir, ErrDestination)
+	}
+
+	if !strings.HasSuffix(destDir, string(os.PathSeparator)) {
+		destDir = destDir + string(os.PathSeparator)
+	}
+	return destDir, nil
+}
+
+// DownloadWithOptions downloads the contents of the bucket into an archive, or a directory
+// mirroring the object keys, within the given destination directory. Only the objects selected
+// by the filters and within the size limits are fetched, and objects whose keys are absolute or
+// traverse up with .. are rejected, as are those the output cannot write at their key's path. Objects are fetched concurrently into a staging directory
+// whose manifest records each object's status and checksum. Failed objects are left out of the
+// output rather than failing the download; the staging directory is then kept, as it is when the
+// context is cancelled, so a later download of the bucket to the same directory resumes without
+// refetching the completed objects. A dry run reports the selection without fetching anything.
+func (b Bucket) DownloadWithOptions(ctx context.Context, destDir string, opts DownloadOptions) (report *DownloadReport, err error) {
+	if len(b.Files) <= 0 {
+		return nil, fmt.Errorf("Bucket %s has no files to download: %w", b.Name, ErrNoFiles)
+	}
+
+	switch opts.Format {
+	case "", ZipFormat, TarGzFormat, DirFormat:
+	default:
+		return nil, fmt.Errorf("Unsupported download format %s: %w", opts.Format, ErrUnsupported)
+	}
+
+	report = &DownloadReport{DryRun: opts.DryRun}
+
+	// select the objects with safe keys by the filters then the size limits of their listed sizes
+	var pending []pendingFile
+	var total int64
+	for idx := range b.Files {
+		file := &b.Files[idx]
+
+		if !safeKey(file.Name) {
+			report.Rejected = append(report.Rejected, file.Name)
+			continue
+		}
+		if !opts.selects(file) {
+			report.Excluded = append(report.Excluded, file.Name)
+			continue
+		}
+
+		limit := opts.MaxObjectSize
+		if opts.MaxTotalSize > 0 {
+			remaining := opts.MaxTotalSize - total
+			if limit <= 0 || remaining < limit {
+				limit = remaining
+			}
+		}
+
+		if limit > 0 && file.Size > limit || opts.MaxTotalSize > 0 && limit <= 0 {
+			report.Skipped = append(report.Skipped, file.Name)
+			continue
+		}
+
+		total += file.Size
+		pending = append(pending, pendingFile{file: file, limit: limit})
+	}
+
+	if opts.DryRun {
+		for _, p := range pending {
+			report.Downloaded = append(report.Downloaded, p.file.Name)
+		}
+		report.Bytes = total
+		return report, nil
+	}
+
+	destDir, err = destination(destDir)
+	if err != nil {
+		return nil, err
+	}
+
+	stagingDir := stagingPath(destDir, b)
+	objectsDir := filepath.Join(stagingDir, "objects")
+	if err = os.MkdirAll(objectsDir, 0755); err != nil {
+		return nil, err
+	}
+
+	manifest, err := loadManifest(stagingDir, b)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read download manifest due to error: %w", err)
+	}
+	report.Manifest = manifest.path
+
+	// Object bodies are streamed so the request timeout bounds each wait for the response headers
+	// or more of the body rather than the whole download
+	client := *b.httpClient()
+	stall := client.Timeout
+	if stall <= 0 {
+		stall = DefaultTimeout
+	}
+	client.Timeout = 0
+
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = DefaultDownloadWorkers
+	}
+
+	var saveErr error
+	var saveOnce sync.Once
+	var wg sync.WaitGroup
+	queue := make(chan pendingFile)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range queue {
+				entry := b.downloadObject(ctx, &client, stall, p, stagedPath(objectsDir, p.file.Name), opts)
+				if err := manifest.update(entry); err != nil {
+					saveOnce.Do(func() { saveErr = err })
+				}
+			}
+		}()
+	}
+
+	// queue the objects not completed by a previous download
+	resumed := make(map[string]bool)
+queueing:
+	for _, p := range pending {
+		if resumable(manifest, p.file, stagedPath(objectsDir, p.file.Name)) {
+			resumed[p.file.Name] = true
+			continue
+		}
+
+		select {
+		case queue <- p:
+		case <-ctx.Done():
+			break queueing
+		}
+	}
+	close(queue)
+	wg.Wait()
+	if err = manifest.close(); err != nil && saveErr == nil {
+		saveErr = err
+	}
+
+	var complete []*File
+	for _, p := range pending {
+		if entry, ok := manifest.entry(p.file.Name); ok && entry.Status == ObjectComplete {
+			complete = append(complete, p.file)
+		}
+	}
+
+	// objects the output cannot write at their key's path are rejected rather than failing it
+	var rejected map[string]bool
+	var outputErr error
+	if saveErr == nil && ctx.Err() == nil {
+		report.Path = outputPath(destDir, b.Name, opts.Format)
+		if rejected, outputErr = writeOutput(report.Path, opts.Format, objectsDir, complete); outputErr != nil {
+			os.RemoveAll(report.Path)
+			report.Path = ""
+		}
+	}
+
+	for _, p := range pending {
+		entry, ok := manifest.entry(p.file.Name)
+		switch {
+		case !ok || entry.Status != ObjectComplete:
+			report.Failed = append(report.Failed, p.file.Name)
+			continue
+		case rejected[p.file.Name]:
+			report.Rejected = append(report.Rejected, p.file.Name)
+			continue
+		}
+
+		report.Downloaded = append(report.Downloaded, p.file.Name)
+		report.Bytes += entry.Size
+		if resumed[p.file.Name] {
+			report.Resumed = append(report.Resumed, p.file.Name)
+		}
+		if entry.Verified == ChecksumMismatch {
+			report.Mismatched = append(report.Mismatched, p.file.Name)
+		}
+	}
+
+	if saveErr != nil {
+		return report, fmt.Errorf("Unable to save download manifest due to error: %w", saveErr)
+	}
+	if ctx.Err() != nil {
+		return report, ctx.Err()
+	}
+	if outputErr != nil {
+		return report, outputErr
+	}
+
+	// the staging directory is only needed to resume incomplete downloads
+	if len(report.Failed) == 0 {
+		os.RemoveAll(stagingDir)
+		report.Manifest = ""
+	}
+
+	return report, nil
+}
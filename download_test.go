@@ -0,0 +1,621 @@
+package bucketscanner_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"gitlab.com/cjbarker/bucketscanner"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readArchive returns the archived file contents keyed by name
+func readArchive(t *testing.T, path string) map[string]string {
+	reader, err := zip.OpenReader(path)
+	if err != nil {
+		t.Fatalf("Unable to open archive due to error: %s", err.Error())
+	}
+	defer reader.Close()
+
+	contents := make(map[string]string)
+	for _, file := range reader.File {
+		rc, err := file.Open()
+		if err != nil {
+			t.Fatalf("Unable to open archived file due to error: %s", err.Error())
+		}
+		body, _ := ioutil.ReadAll(rc)
+		rc.Close()
+		contents[file.Name] = string(body)
+	}
+	return contents
+}
+
+func TestDownloadWithOptions(t *testing.T) {
+	store := newFakeStore(t, awsFlavour)
+	store.add("sizes", fakePublic, map[string]string{
+		"a.txt":  "aaaa",
+		"b.txt":  "bbbbbbbbbb",
+		"c.txt":  "cc",
+		"d/":     "",
+		"d/e.md": "eeee",
+	})
+
+	bucket, err := bucketscanner.NewAwsScanner(store.options()...).Read("sizes")
+	if err != nil {
+		t.Fatalf("Unexpected read error: %s", err.Error())
+	}
+
+	dir, err := ioutil.TempDir("", "download")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir due to error: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		opts       bucketscanner.DownloadOptions
+		downloaded []string
+		skipped    []string
+		bytes      int64
+	}{
+		{bucketscanner.DownloadOptions{}, []string{"a.txt", "b.txt", "c.txt", "d/", "d/e.md"}, nil, 20},
+		{bucketscanner.DownloadOptions{MaxObjectSize: 4}, []string{"a.txt", "c.txt", "d/", "d/e.md"}, []string{"b.txt"}, 10},
+		{bucketscanner.DownloadOptions{MaxTotalSize: 7}, []string{"a.txt", "c.txt", "d/"}, []string{"b.txt", "d/e.md"}, 6},
+	}
+
+	for _, test := range tests {
+		report, err := bucket.DownloadWithOptions(context.Background(), dir, test.opts)
+		if err != nil {
+			t.Fatalf("Unable to download bucket due to error: %s", err.Error())
+		}
+
+		if !reflect.DeepEqual(report.Downloaded, test.downloaded) || !reflect.DeepEqual(report.Skipped, test.skipped) {
+			t.Errorf("Download report error for %+v, got: %v skipped %v", test.opts, report.Downloaded, report.Skipped)
+		}
+		if report.Bytes != test.bytes {
+			t.Errorf("Download bytes error for %+v, got: %d, expected %d", test.opts, report.Bytes, test.bytes)
+		}
+
+		contents := readArchive(t, report.Path)
+		for _, name := range test.downloaded {
+			if contents[name] != string(store.buckets["sizes"].objects[name]) {
+				t.Errorf("Archived file %s content error, got: %s", name, contents[name])
+			}
+		}
+		os.Remove(report.Path)
+	}
+
+	for _, file := range bucket.Files {
+		if len(file.Body) > 0 {
+			t.Errorf("Downloaded file %s body should not be kept in the bucket", file.Name)
+		}
+	}
+}
+
+func TestDownloadEscapesKeys(t *testing.T) {
+	store := newFakeStore(t, awsFlavour)
+	store.add("escaped", fakePublic, map[string]string{
+		"dir/a b?c#d%e.txt": "escaped",
+		"dir/plain.txt":     "plain",
+	})
+
+	bucket, err := bucketscanner.NewAwsScanner(store.options()...).Read("escaped")
+	if err != nil {
+		t.Fatalf("Unexpected read error: %s", err.Error())
+	}
+
+	dir, err := ioutil.TempDir("", "download")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir due to error: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	report, err := bucket.DownloadWithOptions(context.Background(), dir, bucketscanner.DownloadOptions{})
+	if err != nil {
+		t.Fatalf("Unable to download bucket due to error: %s", err.Error())
+	}
+	if len(report.Failed) > 0 || len(report.Downloaded) != 2 {
+		t.Fatalf("Keys with reserved characters should download, got: %v failed %v", report.Downloaded, report.Failed)
+	}
+
+	contents := readArchive(t, report.Path)
+	for name, body := range store.buckets["escaped"].objects {
+		if contents[name] != string(body) {
+			t.Errorf("Archived file %s content error, got: %s", name, contents[name])
+		}
+	}
+}
+
+func TestDownloadFilters(t *testing.T) {
+	store := newFakeStore(t, awsFlavour)
+	store.add("filters", fakePublic, map[string]string{
+		"backup.sql":        "sql",
+		"db/dump.SQL":       "dump",
+		"db/notes.txt":      "notes",
+		"logs/app.log":      "log",
+		"logs/2018/old.log": "old",
+	})
+
+	bucket, err := bucketscanner.NewAwsScanner(store.options()...).Read("filters")
+	if err != nil {
+		t.Fatalf("Unexpected read error: %s", err.Error())
+	}
+
+	listed := time.Date(2018, 4, 11, 18, 31, 16, 0, time.UTC)
+	if !bucket.Files[0].LastModified.Equal(listed) {
+		t.Errorf("File last modified error, got: %s, expected %s", bucket.Files[0].LastModified, listed)
+	}
+
+	tests := []struct {
+		opts       bucketscanner.DownloadOptions
+		downloaded []string
+		bytes      int64
+	}{
+		{bucketscanner.DownloadOptions{Include: []string{"*.sql"}}, []string{"backup.sql"}, 3},
+		{bucketscanner.DownloadOptions{Include: []string{"logs/*"}}, []string{"logs/app.log"}, 3},
+		{bucketscanner.DownloadOptions{Include: []string{"db/*"}, Exclude: []string{"*.txt"}}, []string{"db/dump.SQL"}, 4},
+		{bucketscanner.DownloadOptions{Extensions: []string{"sql", ".LOG"}}, []string{"backup.sql", "db/dump.SQL", "logs/2018/old.log", "logs/app.log"}, 13},
+		{bucketscanner.DownloadOptions{Extensions: []string{"log"}, MaxObjectSize: 3, Exclude: []string{"old.*"}}, []string{"logs/app.log"}, 3},
+		{bucketscanner.DownloadOptions{ModifiedAfter: listed.Add(-time.Hour), ModifiedBefore: listed.Add(time.Hour), Include: []string{"*.txt"}}, []string{"db/notes.txt"}, 5},
+		{bucketscanner.DownloadOptions{ModifiedAfter: listed}, nil, 0},
+		{bucketscanner.DownloadOptions{ModifiedBefore: listed}, nil, 0},
+	}
+
+	for _, test := range tests {
+		test.opts.DryRun = true
+		report, err := bucket.DownloadWithOptions(context.Background(), "/does/not/exist", test.opts)
+		if err != nil {
+			t.Fatalf("Unable to dry run bucket download due to error: %s", err.Error())
+		}
+
+		if !reflect.DeepEqual(report.Downloaded, test.downloaded) || report.Bytes != test.bytes {
+			t.Errorf("Dry run error for %+v, got: %v of %d bytes, expected %v of %d bytes", test.opts, report.Downloaded, report.Bytes, test.downloaded, test.bytes)
+		}
+		if len(report.Downloaded)+len(report.Skipped)+len(report.Excluded) != len(bucket.Files) {
+			t.Errorf("Dry run did not account for every file, got: %+v", report)
+		}
+		if report.Path != "" || !report.DryRun {
+			t.Errorf("Dry run should not create an archive, got: %s", report.Path)
+		}
+	}
+}
+
+func TestDownloadRetryAndResume(t *testing.T) {
+	store := newFakeStore(t, awsFlavour)
+	fake := store.add("resume", fakePublic, numberedObjects(10))
+	fake.failures["key-0003"] = 2
+	fake.failures["key-0007"] = 100
+
+	bucket, err := bucketscanner.NewAwsScanner(store.options()...).Read("resume")
+	if err != nil {
+		t.Fatalf("Unexpected read error: %s", err.Error())
+	}
+
+	dir, err := ioutil.TempDir("", "download")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir due to error: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	// key-0003 succeeds on its third attempt, key-0007 exhausts the retries
+	opts := bucketscanner.DownloadOptions{Workers: 3, Retries: 2, Backoff: time.Millisecond}
+	report, err := bucket.DownloadWithOptions(context.Background(), dir, opts)
+	if err != nil {
+		t.Fatalf("Unable to download bucket due to error: %s", err.Error())
+	}
+	if len(report.Downloaded) != 9 || !reflect.DeepEqual(report.Failed, []string{"key-0007"}) {
+		t.Errorf("Download report error, got: %v failed %v", report.Downloaded, report.Failed)
+	}
+	if len(readArchive(t, report.Path)) != 9 {
+		t.Errorf("Archive should hold the 9 downloaded files")
+	}
+
+	data, err := ioutil.ReadFile(report.Manifest)
+	if err != nil {
+		t.Fatalf("Manifest should be kept for the failed download: %s", err.Error())
+	}
+	var manifest bucketscanner.Manifest
+	if err = json.Unmarshal(data, &manifest); err != nil {
+		t.Fatalf("Unable to parse manifest due to error: %s", err.Error())
+	}
+	if entry := manifest.Objects["key-0003"]; entry.Status != bucketscanner.ObjectComplete || entry.Attempts != 3 || entry.MD5 != "eccbc87e4b5ce2fe28308fd9f2a7baf3" {
+		t.Errorf("Manifest entry error for key-0003, got: %+v", entry)
+	}
+	if entry := manifest.Objects["key-0007"]; entry.Status != bucketscanner.ObjectFailed || entry.Attempts != 3 || entry.Error == "" {
+		t.Errorf("Manifest entry error for key-0007, got: %+v", entry)
+	}
+
+	// resuming only refetches the failed object
+	fake.failures["key-0007"] = 0
+	store.gets = nil
+	os.Remove(report.Path)
+
+	report, err = bucket.DownloadWithOptions(context.Background(), dir, opts)
+	if err != nil {
+		t.Fatalf("Unable to resume bucket download due to error: %s", err.Error())
+	}
+	if !reflect.DeepEqual(store.gets, []string{"key-0007"}) {
+		t.Errorf("Resumed download should only fetch key-0007, got: %v", store.gets)
+	}
+	if len(report.Downloaded) != 10 || len(report.Resumed) != 9 || len(report.Failed) != 0 || report.Bytes != 10 {
+		t.Errorf("Resumed download report error, got: %+v", report)
+	}
+	if report.Manifest != "" || len(readArchive(t, report.Path)) != 10 {
+		t.Errorf("Completed download should archive every file and remove its manifest, got: %s", report.Manifest)
+	}
+
+	entries, _ := ioutil.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("Completed download should leave only the archive, got %d entries", len(entries))
+	}
+}
+
+func TestDownloadResumeChecks(t *testing.T) {
+	store := newFakeStore(t, awsFlavour)
+	fake := store.add("acme", fakePublic, numberedObjects(3))
+	fake.failures["key-0002"] = 100
+	other := newFakeStore(t, awsFlavour)
+	other.add("acme", fakePublic, map[string]string{"key-0000": "other", "key-0001": "other", "key-0002": "other"})
+
+	dir, err := ioutil.TempDir("", "download")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir due to error: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	read := func(store *fakeStore) *bucketscanner.Bucket {
+		bucket, err := bucketscanner.NewAwsScanner(store.options()...).Read("acme")
+		if err != nil {
+			t.Fatalf("Unexpected read error: %s", err.Error())
+		}
+		return bucket
+	}
+
+	opts := bucketscanner.DownloadOptions{Retries: 1, Backoff: time.Millisecond}
+	report, err := read(store).DownloadWithOptions(context.Background(), dir, opts)
+	if err != nil {
+		t.Fatalf("Unable to download bucket due to error: %s", err.Error())
+	}
+	if _, err = os.Stat(filepath.Join(filepath.Dir(report.Manifest), "manifest.journal")); !os.IsNotExist(err) {
+		t.Errorf("Manifest journal should be compacted once the download stops, got: %v", err)
+	}
+	os.Remove(report.Path)
+
+	// a bucket of the same name at another URI never resumes the download
+	report, err = read(other).DownloadWithOptions(context.Background(), dir, opts)
+	if err != nil {
+		t.Fatalf("Unable to download bucket due to error: %s", err.Error())
+	}
+	if len(report.Resumed) != 0 || len(report.Downloaded) != 3 {
+		t.Errorf("Download of another bucket URI should not resume, got: %+v", report)
+	}
+	for name, body := range readArchive(t, report.Path) {
+		if body != "other" {
+			t.Errorf("Archived file %s content error, got: %s", name, body)
+		}
+	}
+
+	// an object changed since by its listed ETag is refetched
+	fake.failures["key-0002"] = 0
+	fake.objects["key-0000"] = []byte("changed")
+	store.gets = nil
+
+	report, err = read(store).DownloadWithOptions(context.Background(), dir, opts)
+	if err != nil {
+		t.Fatalf("Unable to resume bucket download due to error: %s", err.Error())
+	}
+	sort.Strings(store.gets)
+	if !reflect.DeepEqual(store.gets, []string{"key-0000", "key-0002"}) || !reflect.DeepEqual(report.Resumed, []string{"key-0001"}) {
+		t.Errorf("Resumed download should refetch the changed object, got: %v resumed %v", store.gets, report.Resumed)
+	}
+	if contents := readArchive(t, report.Path); contents["key-0000"] != "changed" {
+		t.Errorf("Changed object should be archived with its new content, got: %s", contents["key-0000"])
+	}
+}
+
+func TestDownloadStalled(t *testing.T) {
+	store := newFakeStore(t, awsFlavour)
+	fake := store.add("stalled", fakePublic, map[string]string{"stalled.txt": "stalled", "ok.txt": "ok"})
+	fake.stalls["stalled.txt"] = true
+
+	scanner := bucketscanner.NewAwsScanner(append(store.options(), bucketscanner.WithTimeout(100*time.Millisecond))...)
+	bucket, err := scanner.Read("stalled")
+	if err != nil {
+		t.Fatalf("Unexpected read error: %s", err.Error())
+	}
+
+	dir, err := ioutil.TempDir("", "download")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir due to error: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	// the stalled body fails the object once no bytes arrive within the request timeout
+	start := time.Now()
+	report, err := bucket.DownloadWithOptions(context.Background(), dir, bucketscanner.DownloadOptions{Retries: -1})
+	if err != nil {
+		t.Fatalf("Unable to download bucket due to error: %s", err.Error())
+	}
+	if !reflect.DeepEqual(report.Failed, []string{"stalled.txt"}) || !reflect.DeepEqual(report.Downloaded, []string{"ok.txt"}) {
+		t.Errorf("Stalled object should fail alone, got: %v failed %v", report.Downloaded, report.Failed)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Stalled object should time out, took %s", elapsed)
+	}
+}
+
+func TestDownloadCancelled(t *testing.T) {
+	store := newFakeStore(t, awsFlavour)
+	store.add("cancelled", fakePublic, numberedObjects(5))
+
+	bucket, err := bucketscanner.NewAwsScanner(store.options()...).Read("cancelled")
+	if err != nil {
+		t.Fatalf("Unexpected read error: %s", err.Error())
+	}
+
+	dir, err := ioutil.TempDir("", "download")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir due to error: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	report, err := bucket.DownloadWithOptions(ctx, dir, bucketscanner.DownloadOptions{})
+	if err != context.Canceled {
+		t.Fatalf("Cancelled download error, got: %v", err)
+	}
+	if report.Path != "" || report.Manifest == "" || len(report.Failed) != 5 {
+		t.Errorf("Cancelled download should keep the manifest without an archive, got: %+v", report)
+	}
+}
+
+func TestDownloadDirCollisions(t *testing.T) {
+	store := newFakeStore(t, awsFlavour)
+	store.add("collisions", fakePublic, map[string]string{
+		".":       "dot",
+		"a//b":    "empty",
+		"foo":     "file",
+		"foo/bar": "nested",
+		"ok.txt":  "ok",
+	})
+
+	bucket, err := bucketscanner.NewAwsScanner(store.options()...).Read("collisions")
+	if err != nil {
+		t.Fatalf("Unexpected read error: %s", err.Error())
+	}
+
+	dir, err := ioutil.TempDir("", "download")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir due to error: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	// the key clashing with the file foo is rejected rather than failing the whole mirror
+	report, err := bucket.DownloadWithOptions(context.Background(), dir, bucketscanner.DownloadOptions{Format: bucketscanner.DirFormat})
+	if err != nil {
+		t.Fatalf("Unable to download bucket due to error: %s", err.Error())
+	}
+	if !reflect.DeepEqual(report.Rejected, []string{".", "a//b", "foo/bar"}) || !reflect.DeepEqual(report.Downloaded, []string{"foo", "ok.txt"}) {
+		t.Errorf("Download report error, got: %v rejected %v", report.Downloaded, report.Rejected)
+	}
+	if report.Bytes != 6 || len(report.Failed) != 0 || report.Manifest != "" {
+		t.Errorf("Download report error, got: %+v", report)
+	}
+	if contents := readDir(t, report.Path); !reflect.DeepEqual(contents, map[string]string{"foo": "file", "ok.txt": "ok"}) {
+		t.Errorf("Mirror contents error, got: %v", contents)
+	}
+}
+
+func TestDownloadFormats(t *testing.T) {
+	store := newFakeStore(t, awsFlavour)
+	store.add("formats", fakePublic, map[string]string{
+		"../escape.txt":     "escape",
+		"/etc/passwd":       "root",
+		"a/../../b.txt":     "up",
+		`..\windows.txt`:    "windows",
+		`C:\drive.txt`:      "drive",
+		"docs/":             "",
+		"docs/readme.md":    "readme",
+		"index.html":        "<html/>",
+		"nested/a/b/c.json": "{}",
+	})
+
+	bucket, err := bucketscanner.NewAwsScanner(store.options()...).Read("formats")
+	if err != nil {
+		t.Fatalf("Unexpected read error: %s", err.Error())
+	}
+
+	dir, err := ioutil.TempDir("", "download")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir due to error: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	expected := map[string]string{"docs/": "", "docs/readme.md": "readme", "index.html": "<html/>", "nested/a/b/c.json": "{}"}
+	rejected := []string{"../escape.txt", `..\windows.txt`, "/etc/passwd", `C:\drive.txt`, "a/../../b.txt"}
+
+	for _, format := range []string{bucketscanner.ZipFormat, bucketscanner.TarGzFormat, bucketscanner.DirFormat} {
+		report, err := bucket.DownloadWithOptions(context.Background(), dir, bucketscanner.DownloadOptions{Format: format})
+		if err != nil {
+			t.Fatalf("Unable to download bucket as %s due to error: %s", format, err.Error())
+		}
+		if !reflect.DeepEqual(report.Rejected, rejected) {
+			t.Errorf("Rejected keys error for %s, got: %v", format, report.Rejected)
+		}
+
+		name := filepath.Base(report.Path)
+		if strings.Contains(name, ":") || !strings.HasPrefix(name, "bucket-formats-") {
+			t.Errorf("Invalid %s output name: %s", format, name)
+		}
+
+		var contents map[string]string
+		switch format {
+		case bucketscanner.ZipFormat:
+			contents = readArchive(t, report.Path)
+		case bucketscanner.TarGzFormat:
+			contents = readTarGz(t, report.Path)
+		case bucketscanner.DirFormat:
+			contents = readDir(t, report.Path)
+		}
+		if !reflect.DeepEqual(contents, expected) {
+			t.Errorf("Output contents error for %s, got: %v", format, contents)
+		}
+
+		if format != bucketscanner.DirFormat && !strings.HasSuffix(name, "."+format) {
+			t.Errorf("Output name of %s missing extension: %s", format, name)
+		}
+		os.RemoveAll(report.Path)
+	}
+
+	entries, _ := ioutil.ReadDir(dir)
+	if len(entries) != 0 {
+		t.Errorf("Download wrote outside of its output, found %d entries", len(entries))
+	}
+
+	if _, err := bucket.DownloadWithOptions(context.Background(), dir, bucketscanner.DownloadOptions{Format: "rar"}); err == nil {
+		t.Errorf("Error should occur for an unsupported download format")
+	}
+}
+
+// readTarGz returns the archived file contents of a tar.gz keyed by name
+func readTarGz(t *testing.T, path string) map[string]string {
+	archive, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Unable to open archive due to error: %s", err.Error())
+	}
+	defer archive.Close()
+
+	gz, err := gzip.NewReader(archive)
+	if err != nil {
+		t.Fatalf("Unable to decompress archive due to error: %s", err.Error())
+	}
+
+	contents := make(map[string]string)
+	reader := tar.NewReader(gz)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Unable to read archive due to error: %s", err.Error())
+		}
+		body, _ := ioutil.ReadAll(reader)
+		contents[header.Name] = string(body)
+	}
+	return contents
+}
+
+// readDir returns the mirrored file contents keyed by their slash separated relative path, with
+// directories keyed with a trailing slash
+func readDir(t *testing.T, root string) map[string]string {
+	contents := make(map[string]string)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || path == root {
+			return err
+		}
+
+		rel, _ := filepath.Rel(root, path)
+		rel = filepath.ToSlash(rel)
+		if info.IsDir() {
+			// only directories listed as objects are expected
+			if rel == "docs" {
+				contents[rel+"/"] = ""
+			}
+			return nil
+		}
+
+		body, err := ioutil.ReadFile(path)
+		contents[rel] = string(body)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Unable to read mirror due to error: %s", err.Error())
+	}
+	return contents
+}
+
+func TestDownloadVerifiesETags(t *testing.T) {
+	store := newFakeStore(t, awsFlavour)
+	fake := store.add("etags", fakePublic, map[string]string{
+		"single.txt":      "single part",
+		"corrupt.txt":     "not what was listed",
+		"multipart.bin":   "0123456789abcdefghij",
+		"unknown.bin":     "0123456789abcdefghij",
+		"not-md5.txt":     "azure style",
+		"wrong-parts.bin": "0123456789",
+	})
+
+	// multipart ETag of two 10 byte parts
+	first, second := md5.Sum([]byte("0123456789")), md5.Sum([]byte("abcdefghij"))
+	multipart := md5.Sum(append(first[:], second[:]...))
+	fake.etags["multipart.bin"] = hex.EncodeToString(multipart[:]) + "-2"
+	fake.etags["unknown.bin"] = "0123456789abcdef0123456789abcdef-2"
+	fake.etags["wrong-parts.bin"] = hex.EncodeToString(multipart[:]) + "-20"
+	fake.etags["corrupt.txt"] = "0123456789abcdef0123456789abcdef"
+	fake.etags["not-md5.txt"] = "0x8D5A0C5C2C4D7F4"
+
+	// a failed object keeps the manifest so its verification results can be read
+	fake.objects["missing.txt"] = nil
+	fake.failures["missing.txt"] = 100
+
+	bucket, err := bucketscanner.NewAwsScanner(store.options()...).Read("etags")
+	if err != nil {
+		t.Fatalf("Unexpected read error: %s", err.Error())
+	}
+	if bucket.Files[0].ETag != "0123456789abcdef0123456789abcdef" || bucket.Files[0].StorageClass != "STANDARD" {
+		t.Errorf("File listing metadata error, got: %+v", bucket.Files[0])
+	}
+
+	dir, err := ioutil.TempDir("", "download")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir due to error: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	report, err := bucket.DownloadWithOptions(context.Background(), dir, bucketscanner.DownloadOptions{Retries: -1})
+	if err != nil {
+		t.Fatalf("Unable to download bucket due to error: %s", err.Error())
+	}
+	if !reflect.DeepEqual(report.Mismatched, []string{"corrupt.txt"}) {
+		t.Errorf("Mismatched objects error, got: %v", report.Mismatched)
+	}
+	if contents := readArchive(t, report.Path); contents["corrupt.txt"] != "not what was listed" {
+		t.Errorf("Mismatched objects should still be archived, got: %v", contents)
+	}
+
+	data, err := ioutil.ReadFile(report.Manifest)
+	if err != nil {
+		t.Fatalf("Unable to read manifest due to error: %s", err.Error())
+	}
+	var manifest bucketscanner.Manifest
+	if err = json.Unmarshal(data, &manifest); err != nil {
+		t.Fatalf("Unable to parse manifest due to error: %s", err.Error())
+	}
+
+	expected := map[string]string{
+		"corrupt.txt":     bucketscanner.ChecksumMismatch,
+		"multipart.bin":   bucketscanner.ChecksumVerified,
+		"not-md5.txt":     bucketscanner.ChecksumUnverified,
+		"single.txt":      bucketscanner.ChecksumVerified,
+		"unknown.bin":     bucketscanner.ChecksumUnverified,
+		"wrong-parts.bin": bucketscanner.ChecksumUnverified,
+	}
+	for key, verified := range expected {
+		if entry := manifest.Objects[key]; entry == nil || entry.Verified != verified {
+			t.Errorf("Verification error for %s, got: %+v, expected %s", key, entry, verified)
+		}
+	}
+}
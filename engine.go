@@ -0,0 +1,347 @@
+package bucketscanner
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultWorkers is the number of concurrent workers per provider when none is configured
+const DefaultWorkers = 10
+
+// windowFactor is the multiple of the workers of every provider bounding the jobs in flight
+const windowFactor = 4
+
+// DefaultRetryPasses is the number of times rate limited buckets are rescanned when none is configured
+const DefaultRetryPasses = 1
+
+// Action denotes the scan action(s) to invoke against a bucket
+type Action int
+
+// Scan actions
+const (
+	ReadAction  Action = 1 << iota // List the bucket contents via Scanner.Read
+	WriteAction                    // Check write access via Scanner.Write
+	AllActions  = ReadAction | WriteAction
+)
+
+// Job is a bucket name queued to be scanned by a given scanner
+type Job struct {
+	Scanner Scanner
+	Name    string
+	seq     int
+	pass    int // Retry passes the job was rescanned in
+}
+
+// Result is the outcome of a scan job. Bucket may be set alongside Err when only the write
+// action failed.
+type Result struct {
+	Job
+	Bucket *Bucket
+	Err    error
+}
+
+// Engine scans bucket names across scanners with a bounded pool of workers per provider, each
+// pulling from its provider's own job queue so a slow provider never stalls the others' workers
+type Engine struct {
+	Scanners        []Scanner
+	Action          Action
+	Workers         int                        // Concurrent workers per provider
+	ProviderWorkers map[string]int             // Workers keyed by provider name overriding Workers
+	Throttle        time.Duration              // Minimum delay between requests to a given provider
+	RetryPasses     int                        // Rescans of RateLimited buckets once their provider's queue is done
+	Unordered       bool                       // Send results as they complete rather than in queued order
+	Validate        func(Scanner, string) bool // Optional filter of the names queued per scanner
+}
+
+// NewEngine returns a scan engine reading and writing buckets with the default workers
+func NewEngine(scanners ...Scanner) *Engine {
+	return &Engine{
+		Scanners:    scanners,
+		Action:      AllActions,
+		Workers:     DefaultWorkers,
+		RetryPasses: DefaultRetryPasses,
+	}
+}
+
+// ScanBucket invokes the scan action(s) against a given bucket and merges the read and write
+// outcomes into the one bucket result. A bucket is still returned with a write error when
+// the read succeeded.
+func ScanBucket(scanner Scanner, name string, action Action) (bucket *Bucket, err error) {
+	return ScanBucketContext(context.Background(), scanner, name, action)
+}
+
+// ScanBucketContext invokes the scan action(s) against a given bucket bound to the context.
+// Scanners that are not a ContextScanner are only checked for cancellation before each action.
+// Buckets read as Invalid are not written to.
+// A bucket is returned with the error whenever its canary object could not be removed.
+func ScanBucketContext(ctx context.Context, scanner Scanner, name string, action Action) (bucket *Bucket, err error) {
+	ctxScanner, isCtxScanner := scanner.(ContextScanner)
+
+	if action&ReadAction != 0 {
+		if isCtxScanner {
+			bucket, err = ctxScanner.ReadContext(ctx, name)
+		} else if err = ctx.Err(); err == nil {
+			bucket, err = scanner.Read(name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		// a bucket that does not exist cannot be written to
+		if bucket.State == Invalid {
+			return bucket, nil
+		}
+	}
+
+	if action&WriteAction != 0 {
+		if bucket == nil {
+			bucket = &Bucket{
+				Provider: scanner.GetProviderName(),
+				Name:     name,
+				Scanned:  time.Now(),
+			}
+		}
+
+		var result *WriteResult
+		if isCtxScanner {
+			result, err = ctxScanner.WriteContext(ctx, name)
+		} else if err = ctx.Err(); err == nil {
+			result, err = scanner.Write(name)
+		}
+		if result != nil {
+			bucket.Writable = result.Writable
+			bucket.Write = result
+		}
+		if err != nil {
+			// a canary object left behind is always reported with the bucket
+			if action&ReadAction == 0 && (result == nil || !result.CleanupFailed) {
+				return nil, err
+			}
+			return bucket, err
+		}
+	}
+
+	return bucket, nil
+}
+
+// workers returns the number of concurrent workers for the provider
+func (e *Engine) workers(provider string) int {
+	if workers, ok := e.ProviderWorkers[provider]; ok && workers > 0 {
+		return workers
+	}
+	if e.Workers > 0 {
+		return e.Workers
+	}
+	return DefaultWorkers
+}
+
+// run scans the job once the throttle of its provider allows
+func (e *Engine) run(ctx context.Context, job Job, throttle *time.Ticker) Result {
+	if throttle != nil {
+		select {
+		case <-ctx.Done():
+			return Result{Job: job, Err: ctx.Err()}
+		case <-throttle.C:
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return Result{Job: job, Err: err}
+	}
+
+	bucket, err := ScanBucketContext(ctx, job.Scanner, job.Name, e.Action)
+	return Result{Job: job, Bucket: bucket, Err: err}
+}
+
+// rateLimited reports whether the result is of a bucket the provider throttled
+func (r Result) rateLimited() bool {
+	return r.Bucket != nil && r.Bucket.State == RateLimited
+}
+
+// Run queues every name against each scanner and scans them with the worker pool of each
+// provider. Results are sent in queued order, or as they complete when Unordered, over the
+// returned channel which is closed once every job completes. At most a window of jobs is queued,
+// scanning or waiting on an earlier result to be sent, so a slow consumer or provider holds back
+// the queueing of names rather than results piling up. Buckets a provider rate limited are
+// rescanned in up to RetryPasses passes once the provider's queued jobs are done, so their
+// results, and when ordered those queued after them, are only sent once final.
+func (e *Engine) Run(names <-chan string) <-chan Result {
+	return e.RunContext(context.Background(), names)
+}
+
+// providerQueue is the jobs of a provider and its rate limited jobs to rescan
+type providerQueue struct {
+	jobs     chan Job
+	retries  chan Job
+	workers  int
+	throttle *time.Ticker
+}
+
+// RunContext runs the scan bound to the context. Once the context is done no further names are
+// queued and jobs already queued complete with the context error.
+func (e *Engine) RunContext(ctx context.Context, names <-chan string) <-chan Result {
+	// Per provider queues, workers and throttles
+	queues := make(map[string]*providerQueue)
+	total := 0
+	for _, scanner := range e.Scanners {
+		provider := scanner.GetProviderName()
+		if _, ok := queues[provider]; ok {
+			continue
+		}
+		queue := &providerQueue{workers: e.workers(provider)}
+		if e.Throttle > 0 {
+			queue.throttle = time.NewTicker(e.Throttle)
+		}
+		queues[provider] = queue
+		total += queue.workers
+	}
+
+	// Every job in flight holds a slot of the window, so the queued and rate limited jobs of a
+	// provider always fit its queues and a slow provider falls behind by up to the window
+	window := make(chan struct{}, windowFactor*total)
+	for _, queue := range queues {
+		queue.jobs = make(chan Job, cap(window))
+		queue.retries = make(chan Job, cap(window))
+	}
+
+	completed := make(chan Result, total)
+	results := make(chan Result)
+	var inflight sync.WaitGroup
+
+	// Queue jobs
+	queued := make(chan struct{})
+	go func() {
+		defer close(queued)
+		defer func() {
+			for _, queue := range queues {
+				close(queue.jobs)
+			}
+		}()
+
+		seq := 0
+		for {
+			var name string
+			var ok bool
+
+			select {
+			case <-ctx.Done():
+				return
+			case name, ok = <-names:
+				if !ok {
+					return
+				}
+			}
+
+			for _, scanner := range e.Scanners {
+				if e.Validate != nil && !e.Validate(scanner, name) {
+					continue
+				}
+
+				select {
+				case <-ctx.Done():
+					return
+				case window <- struct{}{}:
+				}
+				inflight.Add(1)
+
+				select {
+				case <-ctx.Done():
+					<-window
+					inflight.Done()
+					return
+				case queues[scanner.GetProviderName()].jobs <- Job{Scanner: scanner, Name: name, seq: seq}:
+				}
+				seq++
+			}
+		}
+	}()
+
+	// Workers of each provider scan its queued jobs, preferring them to the rate limited jobs
+	// held back for a retry pass so those are only rescanned once the provider has slowed down
+	var wg sync.WaitGroup
+	for _, queue := range queues {
+		wg.Add(queue.workers)
+		for i := 0; i < queue.workers; i++ {
+			go func(queue *providerQueue) {
+				defer wg.Done()
+
+				jobs := queue.jobs
+				for {
+					var job Job
+					var ok bool
+
+					select {
+					case job, ok = <-jobs:
+					default:
+						select {
+						case job, ok = <-jobs:
+						case job, ok = <-queue.retries:
+							if !ok {
+								return
+							}
+						}
+					}
+					if !ok {
+						jobs = nil
+						continue
+					}
+
+					result := e.run(ctx, job, queue.throttle)
+					if job.pass < e.RetryPasses && result.rateLimited() && ctx.Err() == nil {
+						job.pass++
+						queue.retries <- job
+						continue
+					}
+					completed <- result
+					inflight.Done()
+				}
+			}(queue)
+		}
+	}
+
+	go func() {
+		<-queued
+		inflight.Wait()
+		for _, queue := range queues {
+			close(queue.retries)
+		}
+		wg.Wait()
+
+		close(completed)
+		for _, queue := range queues {
+			if queue.throttle != nil {
+				queue.throttle.Stop()
+			}
+		}
+	}()
+
+	// Collect results in queued order, freeing a slot of the window as each is sent
+	go func() {
+		defer close(results)
+
+		if e.Unordered {
+			for result := range completed {
+				results <- result
+				<-window
+			}
+			return
+		}
+
+		pending := make(map[int]Result)
+		next := 0
+		for result := range completed {
+			pending[result.seq] = result
+			for {
+				ready, ok := pending[next]
+				if !ok {
+					break
+				}
+				delete(pending, next)
+				results <- ready
+				<-window
+				next++
+			}
+		}
+	}()
+
+	return results
+}
@@ -0,0 +1,342 @@
+package bucketscanner_test
+
+import (
+	"context"
+	"errors"
+	"gitlab.com/cjbarker/bucketscanner"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stubScanner is an in memory scanner recording the peak number of concurrent reads
+type stubScanner struct {
+	name    string
+	delay   time.Duration
+	mutex   sync.Mutex
+	active  int
+	maxSeen int
+	limited map[string]int // Reads of the name answered as rate limited before it is public
+	reads   int
+	writes  int
+}
+
+func (s *stubScanner) Read(name string) (*bucketscanner.Bucket, error) {
+	s.mutex.Lock()
+	s.reads++
+	s.active++
+	if s.active > s.maxSeen {
+		s.maxSeen = s.active
+	}
+	s.mutex.Unlock()
+
+	time.Sleep(s.delay)
+
+	s.mutex.Lock()
+	s.active--
+	s.mutex.Unlock()
+
+	if name == "error" {
+		return nil, errors.New("Stub read error")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if name == "invalid" {
+		return &bucketscanner.Bucket{Provider: s.name, Name: name, State: bucketscanner.Invalid}, nil
+	}
+	if s.limited[name] > 0 {
+		s.limited[name]--
+		return &bucketscanner.Bucket{Provider: s.name, Name: name, State: bucketscanner.RateLimited}, nil
+	}
+	return &bucketscanner.Bucket{Provider: s.name, Name: name, State: bucketscanner.Public}, nil
+}
+
+func (s *stubScanner) Write(name string) (*bucketscanner.WriteResult, error) {
+	s.mutex.Lock()
+	s.writes++
+	s.mutex.Unlock()
+
+	return &bucketscanner.WriteResult{Writable: name == "writable"}, nil
+}
+
+func (s *stubScanner) GetProviderName() string {
+	return s.name
+}
+
+func sendNames(names ...string) <-chan string {
+	queue := make(chan string)
+	go func() {
+		defer close(queue)
+		for _, name := range names {
+			queue <- name
+		}
+	}()
+	return queue
+}
+
+func TestEngineRun(t *testing.T) {
+	aws := &stubScanner{name: "aws", delay: 5 * time.Millisecond}
+	gcp := &stubScanner{name: "gcp", delay: 5 * time.Millisecond}
+
+	engine := bucketscanner.NewEngine(aws, gcp)
+	engine.Action = bucketscanner.ReadAction
+	engine.Workers = 4
+	engine.ProviderWorkers = map[string]int{"gcp": 2}
+
+	var names []string
+	for i := 0; i < 40; i++ {
+		names = append(names, "bucket-"+strconv.Itoa(i))
+	}
+	names = append(names, "error")
+
+	var results []bucketscanner.Result
+	for result := range engine.Run(sendNames(names...)) {
+		results = append(results, result)
+	}
+
+	if len(results) != len(names)*2 {
+		t.Fatalf("Invalid number of results, got: %d, expected %d", len(results), len(names)*2)
+	}
+
+	// Results are in queued order, each name against each scanner
+	for idx, result := range results {
+		expectedName := names[idx/2]
+		expectedScanner := []*stubScanner{aws, gcp}[idx%2]
+		if result.Name != expectedName || result.Scanner != expectedScanner {
+			t.Errorf("Result %d out of order, got: %s %s", idx, result.Scanner.GetProviderName(), result.Name)
+		}
+		if result.Name == "error" && (result.Err == nil || result.Bucket != nil) {
+			t.Errorf("Result %d should carry the read error", idx)
+		}
+	}
+
+	if aws.maxSeen > 4 || gcp.maxSeen > 2 {
+		t.Errorf("Provider worker limits exceeded, got aws: %d, gcp: %d", aws.maxSeen, gcp.maxSeen)
+	}
+	if aws.maxSeen < 2 {
+		t.Errorf("Expected concurrent aws workers, got: %d", aws.maxSeen)
+	}
+}
+
+func TestEngineRunContext(t *testing.T) {
+	stub := &stubScanner{name: "stub", delay: time.Millisecond}
+	engine := bucketscanner.NewEngine(stub)
+	engine.Workers = 2
+
+	// never ending stream of names
+	names := make(chan string)
+	go func() {
+		for i := 0; ; i++ {
+			names <- "bucket-" + strconv.Itoa(i)
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	scanned := 0
+
+	go func() {
+		defer close(done)
+		for result := range engine.RunContext(ctx, names) {
+			if result.Err == nil {
+				scanned++
+			}
+			if scanned == 3 {
+				cancel()
+			}
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Engine did not stop after its context was cancelled")
+	}
+
+	if scanned < 3 {
+		t.Errorf("Expected partial results before cancellation, got: %d", scanned)
+	}
+}
+
+func TestEngineUnordered(t *testing.T) {
+	slow := &stubScanner{name: "slow", delay: 100 * time.Millisecond}
+	fast := &stubScanner{name: "fast"}
+
+	engine := bucketscanner.NewEngine(slow, fast)
+	engine.Action = bucketscanner.ReadAction
+	engine.Unordered = true
+
+	var providers []string
+	for result := range engine.Run(sendNames("a")) {
+		providers = append(providers, result.Bucket.Provider)
+	}
+
+	// the slow scanner's result is queued first but sent last
+	if strings.Join(providers, ",") != "fast,slow" {
+		t.Errorf("Unordered results should be sent as they complete, got: %v", providers)
+	}
+}
+
+func TestEngineSlowProvider(t *testing.T) {
+	slow := &stubScanner{name: "slow", delay: 50 * time.Millisecond}
+	fast := &stubScanner{name: "fast"}
+
+	engine := bucketscanner.NewEngine(slow, fast)
+	engine.Action = bucketscanner.ReadAction
+	engine.Unordered = true
+	engine.ProviderWorkers = map[string]int{"slow": 1}
+	engine.Validate = func(scanner bucketscanner.Scanner, name string) bool {
+		return strings.HasPrefix(name, scanner.GetProviderName())
+	}
+
+	var names []string
+	for i := 0; i < 20; i++ {
+		names = append(names, "slow"+strconv.Itoa(i))
+	}
+	names = append(names, "fast")
+
+	// the fast provider's job is queued behind the slow provider's but scanned by its own workers
+	start := time.Now()
+	results := engine.Run(sendNames(names...))
+	first := <-results
+	if first.Name != "fast" || time.Since(start) > 500*time.Millisecond {
+		t.Errorf("Slow provider should not stall the fast provider, got: %s after %s", first.Name, time.Since(start))
+	}
+	for range results {
+	}
+}
+
+func TestEngineWindow(t *testing.T) {
+	slow := &stubScanner{name: "slow", delay: 200 * time.Millisecond}
+	fast := &stubScanner{name: "fast"}
+
+	engine := bucketscanner.NewEngine(slow, fast)
+	engine.Action = bucketscanner.ReadAction
+	engine.Workers = 1
+	engine.Validate = func(scanner bucketscanner.Scanner, name string) bool {
+		return strings.HasPrefix(name, scanner.GetProviderName())
+	}
+
+	names := []string{"slow"}
+	for i := 0; i < 50; i++ {
+		names = append(names, "fast"+strconv.Itoa(i))
+	}
+
+	// results queued after the slow bucket wait for it within the window of four jobs per worker
+	results := engine.Run(sendNames(names...))
+	first := <-results
+	fast.mutex.Lock()
+	reads := fast.reads
+	fast.mutex.Unlock()
+	if first.Name != "slow" || reads > 8 {
+		t.Errorf("Jobs in flight should be bounded by the window, got: %s after %d reads", first.Name, reads)
+	}
+
+	count := 1
+	for range results {
+		count++
+	}
+	if count != len(names) {
+		t.Errorf("Invalid number of results, got: %d, expected %d", count, len(names))
+	}
+}
+
+func TestEngineValidate(t *testing.T) {
+	aws := &stubScanner{name: "aws"}
+	gcp := &stubScanner{name: "gcp"}
+
+	engine := bucketscanner.NewEngine(aws, gcp)
+	engine.Validate = func(scanner bucketscanner.Scanner, name string) bool {
+		return scanner == aws
+	}
+
+	count := 0
+	for result := range engine.Run(sendNames("a", "writable")) {
+		count++
+		if result.Scanner != aws {
+			t.Errorf("Name %s should not be queued against an invalid scanner", result.Name)
+		}
+		if result.Bucket.Writable != (result.Name == "writable") {
+			t.Errorf("Write result not merged into bucket %s", result.Name)
+		}
+	}
+
+	if count != 2 {
+		t.Errorf("Invalid number of results, got: %d, expected 2", count)
+	}
+}
+
+func TestEngineRetryPasses(t *testing.T) {
+	stub := &stubScanner{name: "stub", limited: map[string]int{"a": 1, "c": 5}}
+	engine := bucketscanner.NewEngine(stub)
+	engine.Action = bucketscanner.ReadAction
+	engine.RetryPasses = 2
+
+	var names []string
+	var states []bucketscanner.BucketState
+	for result := range engine.Run(sendNames("a", "b", "c")) {
+		names = append(names, result.Name)
+		states = append(states, result.Bucket.State)
+	}
+
+	// a is rescanned once, c is still rate limited after both passes
+	expected := []bucketscanner.BucketState{bucketscanner.Public, bucketscanner.Public, bucketscanner.RateLimited}
+	if strings.Join(names, ",") != "a,b,c" {
+		t.Fatalf("Results should stay in queued order, got: %v", names)
+	}
+	for idx, state := range states {
+		if state != expected[idx] {
+			t.Errorf("Bucket %s state error, got: %s, expected %s", names[idx], state, expected[idx])
+		}
+	}
+	if stub.limited["c"] != 2 {
+		t.Errorf("Rate limited bucket should be scanned once per pass, got %d reads left", stub.limited["c"])
+	}
+
+	engine.RetryPasses = 0
+	stub.limited["a"] = 1
+	for result := range engine.Run(sendNames("a")) {
+		if result.Bucket.State != bucketscanner.RateLimited {
+			t.Errorf("Rate limited bucket should not be rescanned without retry passes, got: %s", result.Bucket.State)
+		}
+	}
+}
+
+func TestScanBucketCleanupFailed(t *testing.T) {
+	store := newAwsStore(t)
+	store.add("writeonly", fakeWriteOnly, nil)
+
+	// write only scans still return the bucket of a canary left behind
+	bucket, err := bucketscanner.ScanBucket(bucketscanner.NewAwsScanner(store.options()...), "writeonly", bucketscanner.WriteAction)
+	if err == nil || bucket == nil || bucket.Write == nil || !bucket.Write.CleanupFailed {
+		t.Errorf("Leftover canary should be reported with the bucket, got: %v, %v", bucket, err)
+	}
+}
+
+func TestScanBucket(t *testing.T) {
+	stub := &stubScanner{name: "stub"}
+
+	bucket, err := bucketscanner.ScanBucket(stub, "writable", bucketscanner.WriteAction)
+	if err != nil {
+		t.Errorf("Unexpected scan error: %s", err.Error())
+	}
+	if bucket.State != bucketscanner.Unknown || !bucket.Writable || bucket.Write == nil {
+		t.Errorf("Write only scan should not read the bucket, got: %v", bucket)
+	}
+
+	_, err = bucketscanner.ScanBucket(stub, "error", bucketscanner.AllActions)
+	if err == nil {
+		t.Errorf("Error should occur when the bucket read fails.")
+	}
+
+	// a bucket that does not exist is never written to
+	stub.writes = 0
+	bucket, err = bucketscanner.ScanBucket(stub, "invalid", bucketscanner.AllActions)
+	if err != nil || bucket.State != bucketscanner.Invalid || bucket.Write != nil || stub.writes != 0 {
+		t.Errorf("Invalid bucket should not be written to, got: %v %v after %d writes", bucket, err, stub.writes)
+	}
+}
@@ -0,0 +1,109 @@
+package bucketscanner
+
+import (
+	"errors"
+	"strconv"
+)
+
+// Sentinel errors of the scanners and downloads, matched with errors.Is including by the typed
+// errors below
+var (
+	ErrInvalidName = errors.New("invalid bucket name")
+	ErrNetwork     = errors.New("network failure")
+	ErrHTTPStatus  = errors.New("unexpected HTTP status")
+	ErrUnsupported = errors.New("not supported by provider")
+	ErrNoFiles     = errors.New("bucket has no files")
+	ErrDestination = errors.New("invalid download destination")
+)
+
+// NameError is a bucket name the provider scanner cannot scan
+type NameError struct {
+	Provider string
+	Name     string
+	Reason   string
+}
+
+func (e *NameError) Error() string {
+	return "Invalid " + withProvider(e.Provider, "bucket name ") + strconv.Quote(e.Name) + ": " + e.Reason
+}
+
+// Is matches ErrInvalidName
+func (e *NameError) Is(target error) bool {
+	return target == ErrInvalidName
+}
+
+// RequestError is a network failure sending a request, including its cancellation
+type RequestError struct {
+	Provider string
+	Method   string
+	URI      string
+	Err      error
+}
+
+func (e *RequestError) Error() string {
+	return "Failed to send " + withProvider(e.Provider, e.Method) + " request to " + e.URI + ": " + e.Err.Error()
+}
+
+// Is matches ErrNetwork
+func (e *RequestError) Is(target error) bool {
+	return target == ErrNetwork
+}
+
+// Unwrap returns the underlying network or context error
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+// StatusError is an HTTP response status the scanner could not interpret
+type StatusError struct {
+	Provider   string
+	URI        string
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return "Failed to get valid " + withProvider(e.Provider, "HTTP response") + " from " + e.URI + " due to STATUS code: " + e.Status
+}
+
+// Is matches ErrHTTPStatus
+func (e *StatusError) Is(target error) bool {
+	return target == ErrHTTPStatus
+}
+
+// UnsupportedError is a scan action the provider scanner does not implement
+type UnsupportedError struct {
+	Provider  string
+	Operation string
+}
+
+func (e *UnsupportedError) Error() string {
+	return withProvider(e.Provider, e.Operation) + " is currently not supported"
+}
+
+// Is matches ErrUnsupported
+func (e *UnsupportedError) Is(target error) bool {
+	return target == ErrUnsupported
+}
+
+// withProvider prefixes the text with the provider name when known
+func withProvider(provider string, text string) string {
+	if provider == "" {
+		return text
+	}
+	return provider + " " + text
+}
+
+// providerError returns the error with the provider set on its typed errors. Requests are sent by
+// helpers shared between the scanners so are attributed to a provider as their errors return.
+func providerError(err error, provider string) error {
+	var requestErr *RequestError
+	if errors.As(err, &requestErr) && requestErr.Provider == "" {
+		requestErr.Provider = provider
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.Provider == "" {
+		statusErr.Provider = provider
+	}
+	return err
+}
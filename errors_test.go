@@ -0,0 +1,116 @@
+package bucketscanner_test
+
+import (
+	"context"
+	"errors"
+	"gitlab.com/cjbarker/bucketscanner"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestNameError(t *testing.T) {
+	_, err := bucketscanner.NewAwsScanner().Read("  ")
+	var nameErr *bucketscanner.NameError
+	if !errors.Is(err, bucketscanner.ErrInvalidName) || !errors.As(err, &nameErr) {
+		t.Fatalf("Blank bucket name error should be a NameError, got: %v", err)
+	}
+	if nameErr.Provider != "Amazon Simple Storage Service (S3)" || nameErr.Name != "  " {
+		t.Errorf("Name error fields error, got: %+v", nameErr)
+	}
+
+	_, err = bucketscanner.NewAzureScanner().Read("//")
+	if !errors.Is(err, bucketscanner.ErrInvalidName) {
+		t.Errorf("Azure name without an account or container should be an invalid name, got: %v", err)
+	}
+}
+
+func TestNameErrorHosts(t *testing.T) {
+	store := newFakeStore(t, awsFlavour)
+	store.add("evil.example", fakeWritable, map[string]string{"container": "redirected"})
+
+	scanners := []bucketscanner.ContextScanner{bucketscanner.NewAwsScanner(store.options()...), bucketscanner.NewGcpScanner(store.options()...)}
+	for _, scanner := range scanners {
+		// names addressing another host or path are never sent
+		for _, name := range []string{"evil.example/container", "evil.example#", "evil.example?", "user@evil.example", "evil.example:8080", "Upper_Case"} {
+			if _, err := scanner.ReadContext(context.Background(), name); !errors.Is(err, bucketscanner.ErrInvalidName) {
+				t.Errorf("%s read of %s should be an invalid name, got: %v", scanner.GetProviderName(), name, err)
+			}
+			if _, err := scanner.WriteContext(context.Background(), name); !errors.Is(err, bucketscanner.ErrInvalidName) {
+				t.Errorf("%s write of %s should be an invalid name, got: %v", scanner.GetProviderName(), name, err)
+			}
+		}
+	}
+
+	if store.requests != 0 {
+		t.Errorf("Invalid names should not be requested, got %d requests", store.requests)
+	}
+}
+
+func TestUnsupportedError(t *testing.T) {
+	scanners := map[string]bucketscanner.Scanner{"bucket": bucketscanner.NewGcpScanner(), "account/bucket": bucketscanner.NewAzureScanner()}
+	for name, scanner := range scanners {
+		_, err := scanner.Write(name)
+		var unsupported *bucketscanner.UnsupportedError
+		if !errors.Is(err, bucketscanner.ErrUnsupported) || !errors.As(err, &unsupported) || unsupported.Provider != scanner.GetProviderName() {
+			t.Errorf("%s write should be unsupported, got: %v", scanner.GetProviderName(), err)
+		}
+	}
+}
+
+func TestRequestError(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	uri := srv.URL
+	srv.Close()
+
+	gcp := bucketscanner.NewGcpScanner(bucketscanner.WithEndpoint(uri), bucketscanner.WithPathStyle())
+	_, err := gcp.Read("closed")
+
+	var requestErr *bucketscanner.RequestError
+	if !errors.Is(err, bucketscanner.ErrNetwork) || !errors.As(err, &requestErr) {
+		t.Fatalf("Connection failure should be a RequestError, got: %v", err)
+	}
+	if requestErr.Provider != gcp.GetProviderName() || requestErr.Method != http.MethodHead || requestErr.URI != uri+"/closed" {
+		t.Errorf("Request error fields error, got: %+v", requestErr)
+	}
+
+	// cancellation remains visible through the request error
+	store := newAwsStore(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = bucketscanner.NewAwsScanner(store.options()...).ReadContext(ctx, PublicBucket)
+	if !errors.Is(err, context.Canceled) || !errors.Is(err, bucketscanner.ErrNetwork) {
+		t.Errorf("Cancelled read should wrap the context error, got: %v", err)
+	}
+}
+
+func TestStatusError(t *testing.T) {
+	store := newFakeStore(t, awsFlavour)
+	store.add("throttled", fakeThrottled, nil)
+
+	aws := bucketscanner.NewAwsScanner(store.options()...)
+	_, err := aws.Write("throttled")
+
+	var statusErr *bucketscanner.StatusError
+	if !errors.Is(err, bucketscanner.ErrHTTPStatus) || !errors.As(err, &statusErr) {
+		t.Fatalf("Unexpected write status should be a StatusError, got: %v", err)
+	}
+	if statusErr.StatusCode != http.StatusServiceUnavailable || statusErr.Provider != aws.GetProviderName() || !strings.Contains(statusErr.URI, "/throttled/") {
+		t.Errorf("Status error fields error, got: %+v", statusErr)
+	}
+}
+
+func TestDownloadErrors(t *testing.T) {
+	_, err := bucketscanner.Bucket{Name: "empty"}.Download(os.TempDir())
+	if !errors.Is(err, bucketscanner.ErrNoFiles) {
+		t.Errorf("Empty bucket download should fail with ErrNoFiles, got: %v", err)
+	}
+
+	bucket := bucketscanner.Bucket{Name: "files", Files: []bucketscanner.File{{Name: "a.txt"}}}
+	_, err = bucket.Download("/does/not/exist")
+	if !errors.Is(err, bucketscanner.ErrDestination) {
+		t.Errorf("Missing destination should fail with ErrDestination, got: %v", err)
+	}
+}
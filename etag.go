@@ -0,0 +1,104 @@
+package bucketscanner
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Download checksum verification results
+const (
+	ChecksumVerified   = "verified"   // Content MD5 matches the listed ETag
+	ChecksumMismatch   = "mismatch"   // Content MD5 does not match the listed single part ETag
+	ChecksumUnverified = "unverified" // No ETag, a non MD5 ETag or a multipart ETag of unknown part size
+)
+
+const mib = 1 << 20
+
+var (
+	md5ETag       = regexp.MustCompile(`^[0-9a-f]{32}$`)
+	multipartETag = regexp.MustCompile(`^[0-9a-f]{32}-([0-9]+)$`)
+
+	// part sizes commonly used by S3 clients for multipart uploads
+	multipartSizes = []int64{5 * mib, 8 * mib, 15 * mib, 16 * mib, 32 * mib, 64 * mib, 100 * mib, 128 * mib, 256 * mib, 512 * mib}
+)
+
+// verifyETag compares the downloaded content of the staged file with the object's listed ETag.
+// Single part S3 ETags are the MD5 of the content, while multipart ETags are the MD5 of the
+// concatenated part MD5s suffixed with the number of parts, so are verified by trying the part
+// sizes that divide the content into that many parts. A multipart ETag that matches none of
+// them may just be of an unusual part size, so is unverified rather than a mismatch.
+func verifyETag(etag string, checksum string, staged string, size int64) string {
+	etag = strings.ToLower(strings.Trim(etag, `"`))
+
+	if md5ETag.MatchString(etag) {
+		if etag == checksum {
+			return ChecksumVerified
+		}
+		return ChecksumMismatch
+	}
+
+	match := multipartETag.FindStringSubmatch(etag)
+	if match == nil {
+		return ChecksumUnverified
+	}
+
+	parts, _ := strconv.ParseInt(match[1], 10, 64)
+	for _, partSize := range partSizes(size, parts) {
+		if sum, err := multipartChecksum(staged, partSize); err == nil && sum == etag {
+			return ChecksumVerified
+		}
+	}
+	return ChecksumUnverified
+}
+
+// partSizes returns the candidate part sizes that split content of the size into the number of
+// parts, starting with the even split and it rounded up to a whole MiB
+func partSizes(size int64, parts int64) (sizes []int64) {
+	if size <= 0 || parts <= 0 {
+		return nil
+	}
+
+	even := (size + parts - 1) / parts
+	seen := make(map[int64]bool)
+	for _, partSize := range append([]int64{even, (even + mib - 1) / mib * mib}, multipartSizes...) {
+		if !seen[partSize] && (size+partSize-1)/partSize == parts {
+			seen[partSize] = true
+			sizes = append(sizes, partSize)
+		}
+	}
+	return sizes
+}
+
+// multipartChecksum returns the multipart ETag of the file uploaded in parts of the given size
+func multipartChecksum(path string, partSize int64) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	var sums []byte
+	parts := 0
+	for {
+		hash := md5.New()
+		n, err := io.CopyN(hash, f, partSize)
+		if n > 0 {
+			sums = hash.Sum(sums)
+			parts++
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+
+	sum := md5.Sum(sums)
+	return hex.EncodeToString(sum[:]) + "-" + strconv.Itoa(parts), nil
+}
@@ -0,0 +1,296 @@
+package bucketscanner_test
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"gitlab.com/cjbarker/bucketscanner"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Fake store flavours mimicking each provider's API
+const (
+	awsFlavour   = "aws"
+	gcpFlavour   = "gcp"
+	azureFlavour = "azure"
+)
+
+// Fake bucket states
+const (
+	fakePublic    = "public"
+	fakePrivate   = "private"
+	fakeThrottled = "throttled" // throttles the first request then behaves as public
+	fakeRedirect  = "redirect"  // redirects to another fake store
+	fakeWritable  = "writable"  // public and accepts anonymous uploads and deletes
+	fakeWriteOnly = "writeonly" // private and accepts anonymous uploads but not deletes
+)
+
+// fakeBucket is a bucket served by the fake store
+type fakeBucket struct {
+	state      string
+	region     string
+	redirect   string
+	pageSize   int
+	v1         bool // Answer listings with ListObjects rather than ListObjectsV2
+	throttle   int
+	retryAfter string            // Retry-After header of the throttled responses
+	failures   map[string]int    // Object GETs answered with an internal error before succeeding
+	stalls     map[string]bool   // Object GETs whose body stops after its first byte until the client gives up
+	etags      map[string]string // Listed ETags overriding the MD5 of the object
+	objects    map[string][]byte
+}
+
+// fakeStore is an httptest based object store serving S3, GCS or Azure style responses for
+// path style bucket URIs e.g. /bucket/key or /account/container/blob
+type fakeStore struct {
+	*httptest.Server
+	flavour  string
+	mutex    sync.Mutex
+	buckets  map[string]*fakeBucket
+	requests int
+	puts     []string
+	gets     []string
+}
+
+// newFakeStore starts a fake store of the provider flavour which is closed with the test
+func newFakeStore(t *testing.T, flavour string) *fakeStore {
+	store := &fakeStore{flavour: flavour, buckets: make(map[string]*fakeBucket)}
+	store.Server = httptest.NewServer(http.HandlerFunc(store.serve))
+	t.Cleanup(store.Close)
+	return store
+}
+
+// add serves a bucket in the given state with the objects of key to body
+func (s *fakeStore) add(name string, state string, objects map[string]string) *fakeBucket {
+	bucket := &fakeBucket{state: state, pageSize: 1000, failures: make(map[string]int), stalls: make(map[string]bool), etags: make(map[string]string), objects: make(map[string][]byte)}
+	for key, body := range objects {
+		bucket.objects[key] = []byte(body)
+	}
+	if state == fakeThrottled {
+		bucket.throttle = 1
+	}
+
+	s.mutex.Lock()
+	s.buckets[name] = bucket
+	s.mutex.Unlock()
+	return bucket
+}
+
+// options returns the scanner options addressing the fake store
+func (s *fakeStore) options() []bucketscanner.Option {
+	return []bucketscanner.Option{bucketscanner.WithEndpoint(s.URL), bucketscanner.WithPathStyle()}
+}
+
+// keys returns the sorted object keys of the bucket
+func (b *fakeBucket) keys() (keys []string) {
+	for key := range b.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *fakeStore) serve(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.requests++
+
+	// bucket (or account/container) and key from the path
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 3)
+	name, key := parts[0], ""
+	if s.flavour == azureFlavour {
+		if len(parts) < 2 {
+			s.error(w, http.StatusNotFound, "ResourceNotFound")
+			return
+		}
+		name = parts[0] + "/" + parts[1]
+		if len(parts) == 3 {
+			key = parts[2]
+		}
+	} else if len(parts) > 1 {
+		key = strings.Join(parts[1:], "/")
+	}
+
+	bucket, ok := s.buckets[name]
+	if !ok {
+		s.error(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	if bucket.region != "" {
+		w.Header().Set("x-amz-bucket-region", bucket.region)
+	}
+
+	switch {
+	case bucket.throttle > 0:
+		bucket.throttle--
+		if bucket.retryAfter != "" {
+			w.Header().Set("Retry-After", bucket.retryAfter)
+		}
+		if s.flavour == gcpFlavour {
+			s.error(w, http.StatusTooManyRequests, "SlowDown")
+		} else {
+			s.error(w, http.StatusServiceUnavailable, "SlowDown")
+		}
+	case bucket.state == fakeRedirect:
+		w.Header().Set("Location", bucket.redirect+r.URL.Path)
+		s.error(w, http.StatusTemporaryRedirect, "TemporaryRedirect")
+	case r.Method == http.MethodPut && (bucket.state == fakeWritable || bucket.state == fakeWriteOnly):
+		body, _ := ioutil.ReadAll(r.Body)
+		bucket.objects[key] = body
+		s.puts = append(s.puts, key)
+	case r.Method == http.MethodDelete && bucket.state == fakeWritable:
+		delete(bucket.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	case bucket.state == fakePrivate || bucket.state == fakeWriteOnly || r.Method == http.MethodPut || r.Method == http.MethodDelete:
+		s.error(w, http.StatusForbidden, "AccessDenied")
+	case key != "":
+		s.gets = append(s.gets, key)
+		if bucket.failures[key] > 0 {
+			bucket.failures[key]--
+			s.error(w, http.StatusInternalServerError, "InternalError")
+			return
+		}
+
+		body, ok := bucket.objects[key]
+		if !ok {
+			s.error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		sum := md5.Sum(body)
+		w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:])+`"`)
+		if bucket.stalls[key] {
+			w.Write(body[:1])
+			w.(http.Flusher).Flush()
+
+			// other requests are served while stalled
+			s.mutex.Unlock()
+			<-r.Context().Done()
+			s.mutex.Lock()
+			return
+		}
+		w.Write(body)
+	case s.flavour == azureFlavour:
+		s.listAzure(w, r, bucket)
+	default:
+		s.listBucket(w, r, bucket)
+	}
+}
+
+// error writes the provider flavoured error response
+func (s *fakeStore) error(w http.ResponseWriter, status int, code string) {
+	if s.flavour == azureFlavour {
+		switch code {
+		case "NoSuchBucket":
+			code = "ContainerNotFound"
+		case "AccessDenied":
+			code = "PublicAccessNotPermitted"
+			status = http.StatusConflict
+		case "SlowDown":
+			code = "ServerBusy"
+		}
+		w.Header().Set("x-ms-error-code", code)
+	}
+
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code></Error>", code)
+}
+
+// page returns the keys of the listing page starting after the marker
+func (b *fakeBucket) page(marker string) (keys []string, next string) {
+	all := b.keys()
+	start := sort.SearchStrings(all, marker)
+	if start < len(all) && all[start] == marker {
+		start++
+	}
+
+	end := start + b.pageSize
+	if end >= len(all) {
+		return all[start:], ""
+	}
+	return all[start:end], all[end-1]
+}
+
+// listBucket writes the S3/GCS ListBucketResult page of ListObjectsV2 or ListObjects
+func (s *fakeStore) listBucket(w http.ResponseWriter, r *http.Request, bucket *fakeBucket) {
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	query := r.URL.Query()
+	v2 := query.Get("list-type") == "2" && !bucket.v1
+
+	// ListObjectsV2 only lists owners when asked to
+	var owner bucketscanner.Owner
+	if !v2 || query.Get("fetch-owner") == "true" {
+		owner = bucketscanner.Owner{ID: "75aa57f09aa0c8caeab4f8c24e99d10f8e7faeebf76c078efc7c6caea54ba06a", DisplayName: "webfile"}
+	}
+
+	marker := query.Get("marker")
+	if v2 {
+		marker = query.Get("continuation-token")
+	}
+	keys, next := bucket.page(marker)
+
+	result := bucketscanner.ListBucketResult{IsTruncated: next != ""}
+	if v2 {
+		result.NextContinuationToken = next
+	}
+	for _, key := range keys {
+		sum := md5.Sum(bucket.objects[key])
+		etag, ok := bucket.etags[key]
+		if !ok {
+			etag = hex.EncodeToString(sum[:])
+		}
+		result.ContentsList = append(result.ContentsList, bucketscanner.Contents{
+			Key:          key,
+			Size:         len(bucket.objects[key]),
+			Etag:         `"` + etag + `"`,
+			LastModified: "2018-04-11T18:31:16.000Z",
+			StorageClass: "STANDARD",
+			Owner:        owner,
+		})
+	}
+
+	xml.NewEncoder(w).Encode(result)
+}
+
+// listAzure writes the Azure EnumerationResults page
+func (s *fakeStore) listAzure(w http.ResponseWriter, r *http.Request, bucket *fakeBucket) {
+	if r.URL.Query().Get("comp") != "list" {
+		s.error(w, http.StatusBadRequest, "InvalidQueryParameterValue")
+		return
+	}
+
+	keys, next := bucket.page(r.URL.Query().Get("marker"))
+
+	result := bucketscanner.EnumerationResults{NextMarker: next}
+	for _, key := range keys {
+		result.Blobs = append(result.Blobs, bucketscanner.Blob{
+			Name: key,
+			Properties: bucketscanner.BlobProperties{
+				ContentLength: int64(len(bucket.objects[key])),
+				ContentType:   "text/plain",
+				LastModified:  "Wed, 11 Apr 2018 18:31:16 GMT",
+			},
+		})
+	}
+
+	xml.NewEncoder(w).Encode(result)
+}
+
+// numberedObjects returns count objects named key-0000 onwards
+func numberedObjects(count int) map[string]string {
+	objects := make(map[string]string)
+	for i := 0; i < count; i++ {
+		objects[fmt.Sprintf("key-%04d", i)] = strconv.Itoa(i)
+	}
+	return objects
+}
@@ -0,0 +1,229 @@
+// Package generator expands seed keywords into candidate bucket names for keyword-driven
+// discovery scans and validates candidates against each cloud provider's naming rules.
+package generator
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Default permutation word lists
+var (
+	DefaultPrefixes = []string{"assets", "cdn", "data", "files", "images", "media",
+		"public", "private", "static", "uploads", "www"}
+	DefaultSuffixes = []string{"archive", "assets", "backups", "bucket", "cdn", "data", "files",
+		"images", "logs", "media", "public", "private", "static", "storage", "uploads"}
+	DefaultEnvironments = []string{"backup", "dev", "development", "prod", "production", "qa",
+		"stage", "staging", "test", "uat"}
+	DefaultSeparators = []string{"", "-", "."}
+)
+
+// Generator expands seed keywords (e.g. company, product or domain) with prefixes, suffixes,
+// environment words, separators and years into candidate bucket names
+type Generator struct {
+	Keywords     []string
+	Prefixes     []string
+	Suffixes     []string
+	Environments []string
+	Separators   []string
+	Years        []int
+}
+
+// New returns a generator for the seed keywords using the default word lists and the
+// current and previous two years
+func New(keywords ...string) *Generator {
+	year := time.Now().Year()
+
+	return &Generator{
+		Keywords:     keywords,
+		Prefixes:     DefaultPrefixes,
+		Suffixes:     DefaultSuffixes,
+		Environments: DefaultEnvironments,
+		Separators:   DefaultSeparators,
+		Years:        []int{year - 2, year - 1, year},
+	}
+}
+
+// Generate streams the de-duplicated candidate bucket names over the returned channel which
+// is closed once every permutation has been sent
+func (g *Generator) Generate() <-chan string {
+	return g.GenerateContext(context.Background())
+}
+
+// GenerateContext streams the candidate bucket names bound to the context. Once the context is
+// done no further names are sent and the channel is closed, so a reader may stop at any time.
+func (g *Generator) GenerateContext(ctx context.Context) <-chan string {
+	names := make(chan string)
+
+	go func() {
+		defer close(names)
+
+		seen := make(map[string]struct{})
+		emit := func(name string) {
+			if _, ok := seen[name]; ok || name == "" || ctx.Err() != nil {
+				return
+			}
+			seen[name] = struct{}{}
+			select {
+			case names <- name:
+			case <-ctx.Done():
+			}
+		}
+
+		for _, base := range g.bases() {
+			if ctx.Err() != nil {
+				return
+			}
+			emit(base)
+
+			for _, sep := range g.separators() {
+				for _, prefix := range g.Prefixes {
+					emit(normalize(prefix) + sep + base)
+				}
+				for _, suffix := range g.Suffixes {
+					emit(base + sep + normalize(suffix))
+				}
+				for _, env := range g.Environments {
+					env = normalize(env)
+					emit(env + sep + base)
+					emit(base + sep + env)
+				}
+				for _, year := range g.Years {
+					emit(base + sep + strconv.Itoa(year))
+				}
+			}
+		}
+	}()
+
+	return names
+}
+
+// bases returns the normalized keywords, the domain labels of any domain keywords and each
+// ordered pairing of two keywords joined by every separator
+func (g *Generator) bases() (bases []string) {
+	var keywords []string
+	for _, keyword := range g.Keywords {
+		keyword = normalize(keyword)
+		if keyword == "" {
+			continue
+		}
+		keywords = append(keywords, keyword)
+
+		// example.com also yields example
+		if idx := strings.Index(keyword, "."); idx > 0 {
+			keywords = append(keywords, keyword[:idx])
+		}
+	}
+
+	bases = append(bases, keywords...)
+	for _, first := range keywords {
+		for _, second := range keywords {
+			if first == second {
+				continue
+			}
+			for _, sep := range g.separators() {
+				bases = append(bases, first+sep+second)
+			}
+		}
+	}
+
+	return bases
+}
+
+// separators returns the configured separators or the default separators if none are set
+func (g *Generator) separators() []string {
+	if len(g.Separators) == 0 {
+		return DefaultSeparators
+	}
+	return g.Separators
+}
+
+// normalize lower cases the word and replaces whitespace with hyphens
+func normalize(word string) string {
+	return strings.Join(strings.Fields(strings.ToLower(word)), "-")
+}
+
+// Validator reports whether a candidate bucket name is valid for a given cloud provider
+type Validator func(name string) bool
+
+// ValidAwsName validates the name against the Amazon S3 bucket naming rules
+// https://docs.aws.amazon.com/AmazonS3/latest/userguide/bucketnamingrules.html
+func ValidAwsName(name string) bool {
+	if len(name) < 3 || len(name) > 63 || !validChars(name, "-.") || !alnumEnds(name) {
+		return false
+	}
+	if strings.Contains(name, "..") || strings.Contains(name, ".-") || strings.Contains(name, "-.") {
+		return false
+	}
+	if net.ParseIP(name) != nil {
+		return false
+	}
+	for _, prefix := range []string{"xn--", "sthree-", "amzn-s3-demo-"} {
+		if strings.HasPrefix(name, prefix) {
+			return false
+		}
+	}
+	for _, suffix := range []string{"-s3alias", "--ol-s3", ".mrap", "--x-s3"} {
+		if strings.HasSuffix(name, suffix) {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidGcpName validates the name against the Google Cloud Storage bucket naming rules
+// https://cloud.google.com/storage/docs/buckets#naming
+func ValidGcpName(name string) bool {
+	if len(name) < 3 || len(name) > 222 || !validChars(name, "-._") || !alnumEnds(name) {
+		return false
+	}
+	if !strings.Contains(name, ".") && len(name) > 63 {
+		return false
+	}
+	for _, component := range strings.Split(name, ".") {
+		if component == "" || len(component) > 63 {
+			return false
+		}
+	}
+	if net.ParseIP(name) != nil || strings.HasPrefix(name, "goog") {
+		return false
+	}
+	return !strings.Contains(name, "google") && !strings.Contains(name, "g00gle")
+}
+
+// ValidAzureName validates an account/container name against the Azure storage account and
+// container naming rules. A name without a container is validated as both.
+// https://docs.microsoft.com/en-us/rest/api/storageservices/naming-and-referencing-containers--blobs--and-metadata
+func ValidAzureName(name string) bool {
+	account, container := name, name
+	if idx := strings.Index(name, "/"); idx > -1 {
+		account, container = name[:idx], name[idx+1:]
+	}
+
+	if len(account) < 3 || len(account) > 24 || !validChars(account, "") {
+		return false
+	}
+	if len(container) < 3 || len(container) > 63 || !validChars(container, "-") || !alnumEnds(container) {
+		return false
+	}
+	return !strings.Contains(container, "--")
+}
+
+// validChars determines whether the name only contains lower case letters, numbers and
+// the given extra characters
+func validChars(name string, extra string) bool {
+	for _, r := range name {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && !strings.ContainsRune(extra, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// alnumEnds determines whether the name begins and ends with a letter or number
+func alnumEnds(name string) bool {
+	return validChars(name[:1], "") && validChars(name[len(name)-1:], "")
+}
@@ -0,0 +1,110 @@
+package generator_test
+
+import (
+	"context"
+	"gitlab.com/cjbarker/bucketscanner/generator"
+	"testing"
+	"time"
+)
+
+func TestGenerate(t *testing.T) {
+	gen := &generator.Generator{
+		Keywords:     []string{"Acme Corp", "acme.com"},
+		Prefixes:     []string{"www"},
+		Suffixes:     []string{"logs"},
+		Environments: []string{"dev"},
+		Separators:   []string{"-"},
+		Years:        []int{2018},
+	}
+
+	names := make(map[string]int)
+	for name := range gen.Generate() {
+		names[name]++
+	}
+
+	expected := []string{"acme-corp", "acme.com", "acme", "www-acme", "acme-logs", "dev-acme",
+		"acme-dev", "acme-2018", "acme-corp-acme", "acme-acme-corp"}
+	for _, name := range expected {
+		if names[name] != 1 {
+			t.Errorf("Expected generated name %s once, got: %d", name, names[name])
+		}
+	}
+
+	for name, count := range names {
+		if count > 1 {
+			t.Errorf("Generated name %s was not de-duplicated, got: %d", name, count)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	gen := generator.New("acme")
+	if len(gen.Years) != 3 {
+		t.Errorf("Expected default years, got: %v", gen.Years)
+	}
+
+	count := 0
+	for range gen.Generate() {
+		count++
+	}
+	if count < 100 {
+		t.Errorf("Expected default word lists to generate at least 100 names, got: %d", count)
+	}
+}
+
+func TestGenerateContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	names := generator.New("acme").GenerateContext(ctx)
+
+	// the reader stops after the first name
+	<-names
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range names {
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Generator did not stop after its context was cancelled")
+	}
+}
+
+func TestValidators(t *testing.T) {
+	tests := []struct {
+		name      string
+		validator generator.Validator
+		bucket    string
+		expected  bool
+	}{
+		{"aws", generator.ValidAwsName, "acme-logs", true},
+		{"aws", generator.ValidAwsName, "acme.logs.2018", true},
+		{"aws", generator.ValidAwsName, "ac", false},
+		{"aws", generator.ValidAwsName, "Acme", false},
+		{"aws", generator.ValidAwsName, "acme_logs", false},
+		{"aws", generator.ValidAwsName, "acme..logs", false},
+		{"aws", generator.ValidAwsName, "-acme", false},
+		{"aws", generator.ValidAwsName, "192.168.5.4", false},
+		{"aws", generator.ValidAwsName, "xn--acme", false},
+		{"gcp", generator.ValidGcpName, "acme_logs", true},
+		{"gcp", generator.ValidGcpName, "acme.logs", true},
+		{"gcp", generator.ValidGcpName, "google-acme", false},
+		{"gcp", generator.ValidGcpName, "goog-acme", false},
+		{"gcp", generator.ValidGcpName, "acme-", false},
+		{"azure", generator.ValidAzureName, "acmelogs", true},
+		{"azure", generator.ValidAzureName, "acme/acme-logs", true},
+		{"azure", generator.ValidAzureName, "acme-logs", false},
+		{"azure", generator.ValidAzureName, "acme/acme--logs", false},
+		{"azure", generator.ValidAzureName, "acmelogsacmelogsacmelogs1/logs", false},
+	}
+
+	for _, test := range tests {
+		if test.validator(test.bucket) != test.expected {
+			t.Errorf("Invalid %s name validation of %s, expected %t", test.name, test.bucket, test.expected)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package bucketscanner
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// manifestName is the file within a download's staging directory recording its progress
+const manifestName = "manifest.json"
+
+// journalName is the file appended with each entry of the manifest until it is compacted into the
+// manifest once the download stops
+const journalName = "manifest.journal"
+
+// Manifest object download statuses
+const (
+	ObjectComplete = "complete"
+	ObjectFailed   = "failed"
+)
+
+// ManifestEntry records the download progress of a bucket object
+type ManifestEntry struct {
+	Key      string `json:"key"`
+	Status   string `json:"status"`
+	Size     int64  `json:"size"`
+	ETag     string `json:"etag,omitempty"`
+	MD5      string `json:"md5,omitempty"`
+	Verified string `json:"verified,omitempty"` // ChecksumVerified, ChecksumMismatch or ChecksumUnverified
+	Attempts int    `json:"attempts"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Manifest records the status and checksum of each object of a bucket download so an
+// interrupted download can be resumed without refetching the completed objects. Entries are
+// appended to a journal as each object completes and compacted into the manifest on close.
+type Manifest struct {
+	Bucket  string                    `json:"bucket"`
+	URI     string                    `json:"uri"`
+	Objects map[string]*ManifestEntry `json:"objects"`
+	path    string
+	journal *os.File
+	mutex   sync.Mutex
+}
+
+// loadManifest reads the manifest of the staging directory and replays its journal, or else
+// returns a new empty manifest of the bucket, then opens a new journal. A manifest of another
+// bucket URI is discarded.
+func loadManifest(stagingDir string, b Bucket) (*Manifest, error) {
+	m := &Manifest{
+		Bucket:  b.Name,
+		URI:     b.URI,
+		Objects: make(map[string]*ManifestEntry),
+		path:    filepath.Join(stagingDir, manifestName),
+	}
+
+	data, err := ioutil.ReadFile(m.path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err = json.Unmarshal(data, m); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err = ioutil.ReadFile(m.journalPath())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if m.Objects == nil {
+		m.Objects = make(map[string]*ManifestEntry)
+	}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		var entry ManifestEntry
+		// a line cut short by an interruption ends the journal
+		if err = json.Unmarshal(line, &entry); err != nil {
+			break
+		}
+		m.Objects[entry.Key] = &entry
+	}
+
+	if m.Bucket != b.Name || m.URI != b.URI {
+		m.Bucket = b.Name
+		m.URI = b.URI
+		m.Objects = make(map[string]*ManifestEntry)
+	}
+
+	// the journal is compacted before it is reopened so replayed entries are never appended twice
+	if err = m.save(); err != nil {
+		return nil, err
+	}
+	if m.journal, err = os.Create(m.journalPath()); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// journalPath returns the path of the manifest's journal
+func (m *Manifest) journalPath() string {
+	return filepath.Join(filepath.Dir(m.path), journalName)
+}
+
+// entry returns a copy of the key's manifest entry, if any
+func (m *Manifest) entry(key string) (entry ManifestEntry, ok bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if e, ok := m.Objects[key]; ok {
+		return *e, true
+	}
+	return entry, false
+}
+
+// update records the entry and appends it to the journal as a JSON line
+func (m *Manifest) update(entry ManifestEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.Objects[entry.Key] = &entry
+	_, err = m.journal.Write(append(data, '\n'))
+	return err
+}
+
+// close compacts the journal into the manifest then removes it
+func (m *Manifest) close() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if err := m.journal.Close(); err != nil {
+		return err
+	}
+	if err := m.save(); err != nil {
+		return err
+	}
+	return os.Remove(m.journalPath())
+}
+
+// save writes the manifest to a temporary file renamed over the previous one so an interruption
+// never leaves it half written
+func (m *Manifest) save() error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := m.path + ".tmp"
+	if err = ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.path)
+}
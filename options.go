@@ -0,0 +1,134 @@
+package bucketscanner
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Option configures a provider scanner created by NewAwsScanner, NewGcpScanner or NewAzureScanner
+type Option func(*options)
+
+// options are the settings shared by the provider scanners. The zero value scans the
+// provider's public endpoint with a default HTTP client.
+type options struct {
+	client     *http.Client
+	endpoint   string
+	pathStyle  bool
+	maxObjects int
+	timeout    time.Duration
+	rate       float64
+	limiter    *RateLimiter
+}
+
+// WithHTTPClient sends every scanner request including downloads via the given client e.g. to
+// set TLS options, a test transport or a ProxyPool
+func WithHTTPClient(client *http.Client) Option {
+	return func(o *options) {
+		o.client = client
+	}
+}
+
+// WithEndpoint scans against the base endpoint URI e.g. http://localhost:9000 for an S3
+// compatible store instead of the provider's public endpoint
+func WithEndpoint(endpoint string) Option {
+	return func(o *options) {
+		o.endpoint = strings.TrimSuffix(endpoint, "/")
+	}
+}
+
+// WithPathStyle addresses buckets as endpoint/bucket rather than the virtual host bucket.endpoint
+func WithPathStyle() Option {
+	return func(o *options) {
+		o.pathStyle = true
+	}
+}
+
+// WithMaxObjects caps the number of objects listed per bucket; zero or less lists all
+func WithMaxObjects(maxObjects int) Option {
+	return func(o *options) {
+		o.maxObjects = maxObjects
+	}
+}
+
+// WithTimeout sets the per request timeout; zero or less uses DefaultTimeout
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.timeout = timeout
+	}
+}
+
+// WithRateLimit paces the scanner's requests to at most the requests per second, slowing further
+// while the provider throttles; zero or less is unlimited until it throttles
+func WithRateLimit(rate float64) Option {
+	return func(o *options) {
+		o.rate = rate
+	}
+}
+
+// WithRateLimiter paces the scanner's requests by the given limiter e.g. to share one between
+// scanners of the same provider. WithRateLimit is ignored.
+func WithRateLimiter(limiter *RateLimiter) Option {
+	return func(o *options) {
+		o.limiter = limiter
+	}
+}
+
+// newOptions applies the options over the zero value settings. Each scanner has its own rate
+// limiter, shared by its copies, unless one is given.
+func newOptions(opts []Option) (o options) {
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.limiter == nil {
+		o.limiter = NewRateLimiter(o.rate)
+	}
+	return o
+}
+
+// httpClient returns the configured client, or else a default one, applying the timeout and
+// optionally not following redirects so they can be inspected. Requests are paced by the rate
+// limiter, or else one of the client alone for scanners not created by their constructor.
+func (o options) httpClient(followRedirects bool) *http.Client {
+	var client http.Client
+	if o.client == nil {
+		client = *newClient(o.timeout, followRedirects)
+	} else {
+		client = *o.client
+		if o.timeout > 0 {
+			client.Timeout = o.timeout
+		}
+		if !followRedirects {
+			client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			}
+		}
+	}
+
+	limiter := o.limiter
+	if limiter == nil {
+		limiter = NewRateLimiter(0)
+	}
+	client.Transport = &rateLimitedTransport{base: client.Transport, limiter: limiter}
+	return &client
+}
+
+// bucketURI returns the URI of the named bucket on the configured endpoint, or else from the
+// provider's default URI template
+func (o options) bucketURI(defaultURI string, name string) (uri string, err error) {
+	if o.endpoint == "" {
+		return strings.Replace(defaultURI, bucketName, name, 1), nil
+	}
+
+	if o.pathStyle {
+		return o.endpoint + "/" + name, nil
+	}
+
+	endpoint, err := url.Parse(o.endpoint)
+	if err != nil {
+		return "", err
+	}
+	endpoint.Host = name + "." + endpoint.Host
+	return endpoint.String(), nil
+}
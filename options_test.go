@@ -0,0 +1,90 @@
+package bucketscanner_test
+
+import (
+	"context"
+	"fmt"
+	"gitlab.com/cjbarker/bucketscanner"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const listing = `<ListBucketResult><Name>public</Name><IsTruncated>false</IsTruncated>
+<Contents><Key>index.html</Key><Size>10</Size></Contents></ListBucketResult>`
+
+func TestPathStyleEndpoint(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/public":
+			fmt.Fprint(w, listing)
+		case "/acct/container":
+			fmt.Fprint(w, `<EnumerationResults><Blobs><Blob><Name>index.html</Name>
+<Properties><Content-Length>10</Content-Length></Properties></Blob></Blobs></EnumerationResults>`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	scanners := []bucketscanner.Scanner{
+		bucketscanner.NewAwsScanner(bucketscanner.WithEndpoint(srv.URL), bucketscanner.WithPathStyle()),
+		bucketscanner.NewGcpScanner(bucketscanner.WithEndpoint(srv.URL+"/"), bucketscanner.WithPathStyle()),
+	}
+
+	for _, scanner := range scanners {
+		bucket, err := scanner.Read("public")
+		if err != nil {
+			t.Fatalf("Unexpected %s read error: %s", scanner.GetProviderName(), err.Error())
+		}
+		if bucket.URI != srv.URL+"/public" {
+			t.Errorf("Invalid %s bucket URI, got: %s", scanner.GetProviderName(), bucket.URI)
+		}
+		if bucket.State != bucketscanner.Public || bucket.NoFiles != 1 {
+			t.Errorf("Invalid %s bucket, got state: %d, files: %d", scanner.GetProviderName(), bucket.State, bucket.NoFiles)
+		}
+	}
+
+	azure := bucketscanner.NewAzureScanner(bucketscanner.WithEndpoint(srv.URL), bucketscanner.WithPathStyle())
+	bucket, err := azure.Read("acct/container")
+	if err != nil {
+		t.Fatalf("Unexpected Azure read error: %s", err.Error())
+	}
+	if bucket.URI != srv.URL+"/acct/container" || bucket.State != bucketscanner.Public || bucket.NoFiles != 1 {
+		t.Errorf("Invalid Azure bucket, got uri: %s, state: %d, files: %d", bucket.URI, bucket.State, bucket.NoFiles)
+	}
+}
+
+func TestVirtualHostEndpoint(t *testing.T) {
+	var hosts []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hosts = append(hosts, r.Host)
+		fmt.Fprint(w, listing)
+	}))
+	defer srv.Close()
+
+	// every host name resolves to the test server
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network string, addr string) (net.Conn, error) {
+				return net.Dial(network, srv.Listener.Addr().String())
+			},
+		},
+	}
+
+	aws := bucketscanner.NewAwsScanner(bucketscanner.WithEndpoint("http://s3.test"), bucketscanner.WithHTTPClient(client))
+	bucket, err := aws.Read("public")
+	if err != nil {
+		t.Fatalf("Unexpected read error: %s", err.Error())
+	}
+	if bucket.URI != "http://public.s3.test" || bucket.State != bucketscanner.Public {
+		t.Errorf("Invalid bucket, got uri: %s, state: %d", bucket.URI, bucket.State)
+	}
+
+	for _, host := range hosts {
+		if !strings.HasPrefix(host, "public.s3.test") {
+			t.Errorf("Request not sent to the virtual host, got: %s", host)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package bucketscanner
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Adaptive rate limiting settings of the RateLimiter
+const (
+	throttledRate      = 10.0                   // Requests per second of an unlimited provider once it throttles
+	minRate            = 0.5                    // Slowest rate a throttling provider is slowed to
+	recoverAfter       = 10                     // Consecutive accepted requests before the rate increases
+	recoverFactor      = 1.5                    // Rate multiplier of each recovery step
+	minThrottleBackoff = 500 * time.Millisecond // Pause after throttling without a Retry-After
+	maxThrottleBackoff = 10 * time.Second
+	maxRetryAfter      = time.Minute // Longest Retry-After honoured
+	maxThrottleRetries = 6           // Throttled retries of a bucket before it is reported RateLimited
+)
+
+// RateLimiter is a token bucket pacing the requests sent to a provider which adapts to the
+// provider's throttling. A throttled response, 503 (S3 SlowDown, Azure ServerBusy) or 429, pauses
+// every request for its Retry-After, or else a growing backoff, and halves the rate. The rate
+// recovers gradually, increasing after each run of accepted requests until it is back to its
+// configured maximum. A RateLimiter is safe for concurrent use and is shared by the copies of a
+// scanner and the downloads of its buckets.
+type RateLimiter struct {
+	mutex     sync.Mutex
+	max       float64       // Configured requests per second, zero is unlimited
+	rate      float64       // Current requests per second, zero is unlimited
+	tokens    float64       // Available requests of the bucket
+	last      time.Time     // Last refill of the tokens
+	until     time.Time     // Requests are paused until
+	backoff   time.Duration // Last pause without a Retry-After
+	successes int           // Consecutive accepted requests since the last rate change
+}
+
+// NewRateLimiter returns a rate limiter allowing up to the requests per second; zero or less is
+// unlimited until the provider throttles
+func NewRateLimiter(rate float64) *RateLimiter {
+	if rate < 0 {
+		rate = 0
+	}
+	return &RateLimiter{max: rate, rate: rate, tokens: 1, last: time.Now()}
+}
+
+// Rate returns the current requests per second, zero being unlimited
+func (l *RateLimiter) Rate() float64 {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.rate
+}
+
+// Wait blocks until a request may be sent or the context is done
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	for {
+		delay := l.reserve(time.Now())
+		if delay <= 0 {
+			return ctx.Err()
+		}
+		if err := sleepContext(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// reserve takes a token returning zero, or else the delay until one may be available
+func (l *RateLimiter) reserve(now time.Time) time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if now.Before(l.until) {
+		return l.until.Sub(now)
+	}
+	if l.rate == 0 {
+		return 0
+	}
+
+	// Refill up to a burst of one second of requests
+	if now.After(l.last) {
+		l.tokens = math.Min(math.Max(1, l.rate), l.tokens+now.Sub(l.last).Seconds()*l.rate)
+		l.last = now
+	}
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0
+	}
+	return time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+}
+
+// Throttled pauses the requests for the Retry-After, or else a backoff doubling since the last
+// accepted request when zero, and halves the rate. Signals received during the pause are of
+// requests already in flight so only extend it.
+func (l *RateLimiter) Throttled(retryAfter time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	pause := retryAfter
+	if pause <= 0 {
+		pause = l.backoff
+	}
+	if pause > maxRetryAfter {
+		pause = maxRetryAfter
+	}
+
+	if now.Before(l.until) {
+		if until := now.Add(pause); until.After(l.until) {
+			l.until = until
+		}
+		return
+	}
+
+	if retryAfter <= 0 {
+		l.backoff *= 2
+		if l.backoff < minThrottleBackoff {
+			l.backoff = minThrottleBackoff
+		}
+		if l.backoff > maxThrottleBackoff {
+			l.backoff = maxThrottleBackoff
+		}
+		pause = l.backoff
+	}
+
+	if l.rate == 0 {
+		l.rate = throttledRate
+		if l.max > 0 && l.max < l.rate {
+			l.rate = l.max
+		}
+	} else {
+		l.rate = math.Max(minRate, l.rate/2)
+	}
+
+	// One request may be sent once the pause is over
+	l.until = now.Add(pause)
+	l.last = l.until
+	l.tokens = 1
+	l.successes = 0
+}
+
+// Accepted records a request the provider did not throttle, increasing the rate by a step after
+// each run of accepted requests
+func (l *RateLimiter) Accepted() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.backoff = 0
+	if l.rate == 0 || l.rate == l.max {
+		return
+	}
+
+	l.successes++
+	if l.successes < recoverAfter {
+		return
+	}
+	l.successes = 0
+
+	ceiling := l.max
+	if ceiling == 0 {
+		ceiling = throttledRate
+	}
+	if l.rate*recoverFactor >= ceiling {
+		l.rate = l.max
+	} else {
+		l.rate *= recoverFactor
+	}
+}
+
+// throttled reports whether the response is a provider throttling its requests
+func throttled(resp *http.Response) bool {
+	return resp.StatusCode == http.StatusServiceUnavailable || resp.StatusCode == http.StatusTooManyRequests
+}
+
+// retryAfter returns the pause of the response's Retry-After header in seconds or as an HTTP
+// date, or else zero
+func retryAfter(resp *http.Response) time.Duration {
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds <= 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+	return 0
+}
+
+// rateLimitedTransport paces the requests of a client by the rate limiter and feeds it the
+// throttling of their responses
+type rateLimitedTransport struct {
+	base    http.RoundTripper
+	limiter *RateLimiter
+}
+
+func (t *rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.limiter.Wait(req.Context()); err != nil {
+		return nil, err
+	}
+
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if throttled(resp) {
+		t.limiter.Throttled(retryAfter(resp))
+	} else {
+		t.limiter.Accepted()
+	}
+	return resp, nil
+}
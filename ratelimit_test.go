@@ -0,0 +1,83 @@
+package bucketscanner_test
+
+import (
+	"context"
+	"gitlab.com/cjbarker/bucketscanner"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterPaces(t *testing.T) {
+	limiter := bucketscanner.NewRateLimiter(50)
+
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		if err := limiter.Wait(context.Background()); err != nil {
+			t.Fatalf("Unexpected wait error: %s", err.Error())
+		}
+	}
+
+	// the first request is sent at once then one every 20ms
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("Requests not paced to the rate, 6 sent in %s", elapsed)
+	}
+}
+
+func TestRateLimiterAdapts(t *testing.T) {
+	limiter := bucketscanner.NewRateLimiter(4)
+
+	limiter.Throttled(50 * time.Millisecond)
+	if rate := limiter.Rate(); rate != 2 {
+		t.Errorf("Throttled rate should halve, got: %v, expected 2", rate)
+	}
+
+	// throttling of requests in flight during the pause does not slow further
+	limiter.Throttled(0)
+	if rate := limiter.Rate(); rate != 2 {
+		t.Errorf("Throttled rate should only halve once per pause, got: %v, expected 2", rate)
+	}
+
+	start := time.Now()
+	if err := limiter.Wait(context.Background()); err != nil {
+		t.Fatalf("Unexpected wait error: %s", err.Error())
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("Requests should pause for the Retry-After, waited %s", elapsed)
+	}
+
+	// recovers a step per run of accepted requests back to the configured rate
+	for _, expected := range []float64{3, 4, 4} {
+		for i := 0; i < 10; i++ {
+			limiter.Accepted()
+		}
+		if rate := limiter.Rate(); rate != expected {
+			t.Errorf("Recovered rate error, got: %v, expected %v", rate, expected)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	limiter.Throttled(time.Minute)
+	if err := limiter.Wait(ctx); err != context.Canceled {
+		t.Errorf("Wait should stop once the context is done, got: %v", err)
+	}
+}
+
+func TestRateLimiterUnlimited(t *testing.T) {
+	limiter := bucketscanner.NewRateLimiter(0)
+	if rate := limiter.Rate(); rate != 0 {
+		t.Errorf("Rate should be unlimited, got: %v", rate)
+	}
+
+	limiter.Throttled(time.Millisecond)
+	if rate := limiter.Rate(); rate <= 0 {
+		t.Errorf("Throttled unlimited rate should be limited, got: %v", rate)
+	}
+
+	for i := 0; i < 10; i++ {
+		limiter.Accepted()
+	}
+	if rate := limiter.Rate(); rate != 0 {
+		t.Errorf("Recovered rate should be unlimited again, got: %v", rate)
+	}
+}
@@ -1,13 +1,17 @@
 package bucketscanner
 
 import (
-	"archive/zip"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"gitlab.com/cjbarker/bucketscanner/generator"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
-	"os"
-	"os/user"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -32,122 +36,156 @@ const (
 	RateLimited             // Unable to determine due to rate limiting e.g. 503 Slow Down
 )
 
+// DefaultTimeout is the per request timeout of the scanners when none is configured
+const DefaultTimeout = 30 * time.Second
+
 // Scanner interface declares functions for cloud provider scanner to implement
 type Scanner interface {
 	Read(name string) (bucket *Bucket, err error)
-	Write(name string) (isWritable bool, err error)
+	Write(name string) (result *WriteResult, err error)
 	GetProviderName() (cloudProviderName string)
 }
 
+// ContextScanner is a Scanner whose requests are cancelled once the given context is done
+type ContextScanner interface {
+	Scanner
+	ReadContext(ctx context.Context, name string) (bucket *Bucket, err error)
+	WriteContext(ctx context.Context, name string) (result *WriteResult, err error)
+}
+
+// Provider scanners support cancellation
+var (
+	_ ContextScanner = AwsScanner{}
+	_ ContextScanner = GcpScanner{}
+	_ ContextScanner = AzureScanner{}
+)
+
 // Bucket structure is the results of a given bucket including its meta-data
 type Bucket struct {
-	Provider  string      `json:"provider"`
-	Name      string      `json:"name"`
-	Scanned   time.Time   `json:"scanned"`
-	URI       string      `json:"uri"`
-	State     BucketState `json:"state"`
-	NoFiles   int64       `json:"noFiles"`
-	noDirs    int64
-	TotalSize int64  `json:"totalSize"`
-	Files     []file `json:"files"`
+	Provider       string      `json:"provider"`
+	Name           string      `json:"name"`
+	Scanned        time.Time   `json:"scanned"`
+	URI            string      `json:"uri"`
+	Region         string      `json:"region,omitempty"`
+	State          BucketState `json:"state"`
+	Writable       bool        `json:"writable"`
+	NoFiles        int64       `json:"noFiles"`
+	noDirs         int64
+	TotalSize      int64           `json:"totalSize"`
+	Truncated      bool            `json:"truncated"` // Listing stopped at the scanner's max objects cap
+	Files          []File          `json:"files"`
+	Write          *WriteResult    `json:"write,omitempty"`
+	DownloadReport *DownloadReport `json:"download,omitempty"` // Outcome of downloading the bucket's files, when downloaded
+	client         *http.Client
 }
 
-// file is a representation of a bucket (object) file
-type file struct {
-	Name  string `json:"name"`
-	IsDir bool   `json:"directory"`
-	Size  int64  `json:"size"`
-	Files []file `json:"files"`
-	Body  []byte
+// WriteResult is the outcome of an anonymous canary object write against a given bucket
+type WriteResult struct {
+	Canary        string `json:"canary"`        // URI of the canary object uploaded
+	Writable      bool   `json:"writable"`      // Canary object was uploaded
+	Deletable     bool   `json:"deletable"`     // Canary object was removed afterwards
+	CleanupFailed bool   `json:"cleanupFailed"` // Canary object was uploaded but could not be removed
 }
 
-// writeToArchive provides recursive HTTP bucket file download and writes to a given archive writer
-func (b Bucket) writeToArchive(bucketFile *file, zipWriter *zip.Writer) (err error) {
-	if &b == nil {
-		return errors.New("Nil bucket unable to write to archive")
-	}
-	if bucketFile == nil || zipWriter == nil {
-		return errors.New("Nil file and/or archive writer passed - unable to write to archive")
-	}
+// File is a representation of a bucket (object) file and the metadata of its listing. Owner is
+// the display name, or else ID, of the object owner when listed and ContentType is only listed
+// by Azure. Body holds downloaded content so is not marshalled. Directories of Bucket.Tree hold
+// their contents in Files and count the files beneath them in NoFiles.
+type File struct {
+	Name         string    `json:"name"`
+	IsDir        bool      `json:"directory"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"lastModified"`
+	ETag         string    `json:"etag,omitempty"`
+	StorageClass string    `json:"storageClass,omitempty"`
+	Owner        string    `json:"owner,omitempty"`
+	ContentType  string    `json:"contentType,omitempty"`
+	NoFiles      int64     `json:"noFiles,omitempty"`
+	Files        []File    `json:"files"`
+	Body         []byte    `json:"-"`
+}
 
-	zipFile, err := zipWriter.Create(bucketFile.Name)
-	if err != nil {
-		return errors.New("Failed to create file in archive " + err.Error())
+// httpClient returns the client of the scanner that read the bucket, or else a default client
+func (b Bucket) httpClient() *http.Client {
+	if b.client != nil {
+		return b.client
 	}
+	return newClient(0, true)
+}
 
-	if len(bucketFile.Body) <= 0 {
-		strBody, err := getHTTPBucket(b.URI + "/" + bucketFile.Name)
-		if err != nil {
-			return err
-		}
-		bucketFile.Body = []byte(*strBody)
+// newClient returns an HTTP client timing out each request after the timeout, or DefaultTimeout
+// if none is set, which optionally does not follow redirects so they can be inspected
+func newClient(timeout time.Duration, followRedirects bool) *http.Client {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
 	}
 
-	_, err = zipFile.Write(bucketFile.Body)
-	if err != nil {
-		return errors.New("Failed to write file to archive " + err.Error())
+	client := &http.Client{Timeout: timeout}
+	if !followRedirects {
+		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
 	}
-
-	return
+	return client
 }
 
-// Download the contents of the bucket to a given destination directory
-func (b Bucket) Download(destDir string) (archivePath *string, err error) {
-
-	// set destination archive path to user's home dir
-	if strings.Trim(destDir, " ") == "" {
-		//return nil, errors.New("Destination directory is not accepted as a blank string")
-		usr, err := user.Current()
-		if err != nil {
-			return nil, err
-		}
-		destDir = usr.HomeDir + string(os.PathSeparator)
+// doRequest sends the HTTP request bound to the context, returning a RequestError on failure
+func doRequest(ctx context.Context, client *http.Client, method string, uri string, body io.Reader) (resp *http.Response, err error) {
+	req, err := http.NewRequestWithContext(ctx, method, uri, body)
+	if err != nil {
+		return nil, err
 	}
 
-	fi, err := os.Lstat(destDir)
-	if os.IsNotExist(err) {
-		return nil, errors.New("Destination file does NOT exist at " + destDir)
+	resp, err = client.Do(req)
+	if err != nil {
+		return nil, &RequestError{Method: method, URI: uri, Err: err}
 	}
+	return resp, nil
+}
 
-	mode := fi.Mode()
-	if !mode.IsDir() {
-		return nil, errors.New("Destination file is NOT a directory " + destDir)
-	}
+// sleepContext pauses for the duration unless the context is done first
+func sleepContext(ctx context.Context, duration time.Duration) error {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
 
-	if len(b.Files) <= 0 {
-		return nil, errors.New("Bucket " + b.Name + " has no files to download")
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
 	}
+}
 
-	if !strings.HasSuffix(destDir, string(os.PathSeparator)) {
-		destDir = destDir + string(os.PathSeparator)
+// checkName returns a NameError for a blank name or one breaking the provider's naming rules, so
+// a name substituted into the bucket URI never addresses another host or path
+func checkName(provider string, name string, valid generator.Validator) error {
+	if strings.Trim(name, " ") == "" {
+		return &NameError{Provider: provider, Name: name, Reason: "Blank strings not accepted for bucket name"}
 	}
-
-	// Create buffer to write to archive
-	t := time.Now()
-	output := destDir + "bucket-" + b.Name + "-" + t.Format(time.RFC3339) + ".zip"
-	newfile, err := os.Create(output)
-	if err != nil {
-		return nil, err
+	if !valid(name) {
+		return &NameError{Provider: provider, Name: name, Reason: "Name breaks the provider's bucket naming rules"}
 	}
-	defer newfile.Close()
-
-	// create zip archive
-	zipWriter := zip.NewWriter(newfile)
-	defer zipWriter.Close()
+	return nil
+}
 
-	// Iterate and download bucket files to the archive
-	for _, file := range b.Files {
-		err = b.writeToArchive(&file, zipWriter)
-		if err != nil {
-			return nil, errors.New("Failed to write file [" + file.Name + "] to archive: " + err.Error())
-		}
+// canaryName returns a unique, clearly labelled object name for write access checks. A failure
+// to read randomness is returned rather than risking a predictable name.
+func canaryName() (name string, err error) {
+	random := make([]byte, 4)
+	if _, err = rand.Read(random); err != nil {
+		return "", fmt.Errorf("Failed to generate canary object name: %w", err)
 	}
+	return "bucketscanner-canary-" + strconv.FormatInt(time.Now().Unix(), 10) + "-" + hex.EncodeToString(random) + ".txt", nil
+}
 
-	return &output, nil
+// canaryBody returns the contents of the canary object explaining why it exists
+func canaryBody() string {
+	return "This object was uploaded anonymously by bucketscanner on " + time.Now().UTC().Format(time.RFC3339) +
+		" to verify the bucket is world-writable. It is safe to delete.\n"
 }
 
 // getHTTPBucket establiesh HTTP connection to the uri and returns contents from HTTP response body
-func getHTTPBucket(uri string) (contents *string, err error) {
+func getHTTPBucket(ctx context.Context, client *http.Client, uri string) (contents *string, err error) {
 	if strings.Trim(uri, " ") == "" {
 		return nil, errors.New("Blank strings not accepted for bucket URI")
 	}
@@ -156,9 +194,14 @@ func getHTTPBucket(uri string) (contents *string, err error) {
 		return nil, err
 	}
 
-	resp, err := http.Get(uri)
+	resp, err := doRequest(ctx, client, http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
 	if resp.StatusCode != 200 {
-		return nil, errors.New("Failed to get valid HTTP response due to STATUS code: " + resp.Status)
+		return nil, &StatusError{URI: uri, StatusCode: resp.StatusCode, Status: resp.Status}
 	}
 
 	// only grab valid response
@@ -167,8 +210,6 @@ func getHTTPBucket(uri string) (contents *string, err error) {
 		return nil, err
 	}
 
-	defer resp.Body.Close()
-
 	contentStr := string(contentBytes)
 	return &contentStr, nil
 }
@@ -1,33 +1,67 @@
 package bucketscanner
 
 import (
+	"context"
 	"encoding/xml"
-	"errors"
+	"fmt"
+	"gitlab.com/cjbarker/bucketscanner/generator"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 )
 
 // Cloud Provider Bucket Constant
 const (
-	awsName = "Amazon Simple Storage Service (S3)"
-	awsURI  = "https://" + bucketName + ".s3.amazonaws.com"
+	awsName         = "Amazon Simple Storage Service (S3)"
+	awsURI          = "https://" + bucketName + ".s3.amazonaws.com"
+	awsRegionalURI  = "https://" + bucketName + ".s3." + regionName + ".amazonaws.com"
+	awsRegionHeader = "x-amz-bucket-region"
+	awsMaxRedirects = 3
 )
 
+// canaryCleanupTimeout bounds the removal of an uploaded canary object, which is still attempted
+// once the scan is cancelled so no debris is left behind
+const canaryCleanupTimeout = 10 * time.Second
+
+const regionName string = "[replace-region-name]"
+
 // AwsScanner is struct for cloud scanner of Amazon Web Services
 type AwsScanner struct {
-	result ListBucketResult
+	options
+}
+
+// NewAwsScanner returns an AWS scanner configured by the options e.g. to scan an S3 compatible store
+func NewAwsScanner(opts ...Option) *AwsScanner {
+	return &AwsScanner{options: newOptions(opts)}
 }
 
-// ListBucketResult is the analyzed results read from a given AWS bucket
+// ListBucketResult is the analyzed results read from a given AWS bucket.
+// It covers both the ListObjects (marker) and ListObjectsV2 (continuation-token) responses.
 type ListBucketResult struct {
-	XMLName      xml.Name `xml:"ListBucketResult"`
-	Name         string
-	Prefix       string
-	Marker       string
-	MaxKeys      int
-	IsTruncated  bool
-	ContentsList []Contents `xml:"Contents"`
+	XMLName               xml.Name `xml:"ListBucketResult"`
+	Name                  string
+	Prefix                string
+	Marker                string
+	NextMarker            string
+	MaxKeys               int
+	KeyCount              int
+	ContinuationToken     string
+	NextContinuationToken string
+	IsTruncated           bool
+	ContentsList          []Contents `xml:"Contents"`
+}
+
+// awsError is the XML error body returned by S3 including redirect details
+type awsError struct {
+	XMLName  xml.Name `xml:"Error"`
+	Code     string
+	Message  string
+	Bucket   string
+	Endpoint string
+	Region   string
 }
 
 // Contents are the XML contents of the bucket (one per file)
@@ -38,15 +72,32 @@ type Contents struct {
 	Etag         string `xml:"ETag"`
 	Size         int
 	StorageClass string
+	Owner        Owner
+}
+
+// Owner is the XML owner of a bucket object
+type Owner struct {
+	ID          string
+	DisplayName string
 }
 
 // Read establishes HTTP connection and reads the contents from the bucket
 func (a AwsScanner) Read(name string) (bucket *Bucket, err error) {
-	if strings.Trim(name, " ") == "" {
-		return nil, errors.New("Blank strings not accepted for bucket name")
+	return a.ReadContext(context.Background(), name)
+}
+
+// ReadContext establishes HTTP connection bound to the context and reads the contents from the bucket
+func (a AwsScanner) ReadContext(ctx context.Context, name string) (bucket *Bucket, err error) {
+	defer func() { err = providerError(err, awsName) }()
+
+	if err = checkName(awsName, name, generator.ValidAwsName); err != nil {
+		return nil, err
 	}
 
-	url := strings.Replace(awsURI, bucketName, name, 1)
+	url, err := a.bucketURI(awsURI, name)
+	if err != nil {
+		return nil, err
+	}
 
 	bucket = &Bucket{
 		Provider: awsName,
@@ -54,17 +105,25 @@ func (a AwsScanner) Read(name string) (bucket *Bucket, err error) {
 		URI:      url,
 		State:    Unknown,
 		Scanned:  time.Now(),
+		client:   a.httpClient(true),
 	}
 
-	var sleepMs int
+	var throttles int
+	var redirects int
+	client := a.httpClient(false)
 
 	// Parse State
 	for bucket.State == Unknown {
 		// Head check before deeper analysis
-		resp, err := http.Head(url)
+		resp, err := doRequest(ctx, client, http.MethodHead, url, nil)
 		if err != nil {
 			return nil, err
 		}
+		resp.Body.Close()
+
+		if region := resp.Header.Get(awsRegionHeader); region != "" {
+			bucket.Region = region
+		}
 
 		switch resp.StatusCode {
 		case 200:
@@ -74,78 +133,310 @@ func (a AwsScanner) Read(name string) (bucket *Bucket, err error) {
 		case 404:
 			bucket.State = Invalid
 		case 503:
-			sleepMs += 500
-			time.Sleep(time.Duration(sleepMs) * time.Millisecond)
-			if sleepMs >= 10000 {
+			// SlowDown pauses the provider's rate limiter before the retry
+			throttles++
+			if throttles > maxThrottleRetries {
 				bucket.State = RateLimited
 			}
+		case 301, 307:
+			// Bucket lives in another region so re-scan against its regional endpoint
+			redirects++
+			if redirects > awsMaxRedirects {
+				return bucket, nil
+			}
+
+			regionalURI, err := a.redirectURI(ctx, client, bucket, resp)
+			if err != nil {
+				return nil, err
+			}
+			if regionalURI == "" || regionalURI == url {
+				return bucket, nil
+			}
+			url = regionalURI
+			bucket.URI = url
 		default:
-			bucket.State = Unknown
+			// Unexpected status leaves the state unknown rather than retrying forever
+			return bucket, nil
 		}
 	}
 
 	// Retrieve available HTTP payload
 	if bucket.State == Public {
-		contents, err := getHTTPBucket(url)
+		err = bucket.listBucket(ctx, bucket.client, url, a.maxObjects)
 		if err != nil {
 			return nil, err
 		}
+	}
 
-		//fmt.Printf("Resp. Bucket Contents: %s\n", *contents)
+	return bucket, nil
+}
+
+// redirectURI determines the regional endpoint of a redirected bucket from the region header,
+// the Location header, or else the PermanentRedirect error body. The region is only mapped to
+// the AWS regional endpoint when no custom endpoint is configured.
+func (a AwsScanner) redirectURI(ctx context.Context, client *http.Client, bucket *Bucket, resp *http.Response) (uri string, err error) {
+	if bucket.Region != "" && a.endpoint == "" {
+		return awsRegionalBucketURI(bucket.Name, bucket.Region), nil
+	}
+
+	if location, err := resp.Location(); err == nil {
+		return a.redirectBucketURI(location.Scheme, location.Host, bucket.Name), nil
+	}
 
-		err = xml.Unmarshal([]byte(*contents), &a.result)
+	// HEAD responses carry no body so request the redirect details
+	getResp, err := doRequest(ctx, client, http.MethodGet, resp.Request.URL.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	defer getResp.Body.Close()
+
+	if region := getResp.Header.Get(awsRegionHeader); region != "" {
+		bucket.Region = region
+		if a.endpoint == "" {
+			return awsRegionalBucketURI(bucket.Name, bucket.Region), nil
+		}
+	}
+
+	body, err := ioutil.ReadAll(getResp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	var redirect awsError
+	if xml.Unmarshal(body, &redirect) != nil || redirect.Endpoint == "" {
+		return "", nil
+	}
+	if redirect.Region != "" {
+		bucket.Region = redirect.Region
+	}
+	return a.redirectBucketURI("https", redirect.Endpoint, bucket.Name), nil
+}
+
+// redirectBucketURI returns the bucket URI on the redirected host honouring path style addressing
+func (a AwsScanner) redirectBucketURI(scheme string, host string, name string) string {
+	if a.pathStyle {
+		return scheme + "://" + host + "/" + name
+	}
+	return scheme + "://" + host
+}
+
+// awsRegionalBucketURI returns the URI of the bucket on the AWS regional endpoint
+func awsRegionalBucketURI(name string, region string) string {
+	uri := strings.Replace(awsRegionalURI, bucketName, name, 1)
+	return strings.Replace(uri, regionName, region, 1)
+}
+
+// listBucket pages through the bucket listing at the given URI until it is exhausted or
+// the max objects cap is reached. ListObjectsV2 is requested, with the object owners it
+// otherwise omits, and the ListObjects marker is followed for servers answering with the
+// original listing.
+func (b *Bucket) listBucket(ctx context.Context, client *http.Client, uri string, maxObjects int) (err error) {
+	query := "?list-type=2&fetch-owner=true"
+
+	for {
+		contents, err := getHTTPBucket(ctx, client, uri+query)
 		if err != nil {
-			return nil, err
+			return err
 		}
 
-		/*
-			fmt.Printf("Result: %s\n", a.result.Name)
-			fmt.Printf("Result: %d\n", a.result.MaxKeys)
-			fmt.Printf("Result: %t\n", a.result.IsTruncated)
-		*/
+		var result ListBucketResult
+		err = xml.Unmarshal([]byte(*contents), &result)
+		if err != nil {
+			return err
+		}
 
-		for _, element := range a.result.ContentsList {
-			bucket.NoFiles++
-			bucket.TotalSize += int64(element.Size)
+		contentsList := result.ContentsList
+		if maxObjects > 0 && int(b.NoFiles)+len(contentsList) > maxObjects {
+			contentsList = contentsList[:maxObjects-int(b.NoFiles)]
+			b.Truncated = true
+		}
+		b.addContents(contentsList)
 
-			var isDir = false
-			if strings.HasSuffix(element.Key, "/") {
-				isDir = true
-			}
+		if b.Truncated || !result.IsTruncated {
+			return nil
+		}
+		if maxObjects > 0 && int(b.NoFiles) >= maxObjects {
+			b.Truncated = true
+			return nil
+		}
 
-			bucketFile := file{
-				Name:  element.Key,
-				Size:  int64(element.Size),
-				IsDir: isDir,
-			}
+		switch {
+		case result.NextContinuationToken != "":
+			query = "?list-type=2&fetch-owner=true&continuation-token=" + url.QueryEscape(result.NextContinuationToken)
+		case result.NextMarker != "":
+			query = "?marker=" + url.QueryEscape(result.NextMarker)
+		case len(result.ContentsList) > 0:
+			// NextMarker is only returned with a delimiter so continue from the last key
+			query = "?marker=" + url.QueryEscape(result.ContentsList[len(result.ContentsList)-1].Key)
+		default:
+			return nil
+		}
+	}
+}
+
+// addContents appends the listed bucket contents as files and tallies the bucket totals
+func (b *Bucket) addContents(contentsList []Contents) {
+	for _, element := range contentsList {
+		b.NoFiles++
+		b.TotalSize += int64(element.Size)
 
-			bucket.Files = append(bucket.Files, bucketFile)
+		var isDir = false
+		if strings.HasSuffix(element.Key, "/") {
+			isDir = true
+		}
 
-			/*
-				fmt.Printf("\nResult: %s\n", element.Key)
-				fmt.Printf("Result: %s\n", element.LastModified)
-				fmt.Printf("Result: %s\n", element.Etag)
-				fmt.Printf("Result: %d\n", element.Size)
-				fmt.Printf("Result: %s\n", element.StorageClass)
-			*/
+		// unparsable timestamps are left as the zero time
+		lastModified, _ := time.Parse(time.RFC3339, element.LastModified)
 
+		bucketFile := File{
+			Name:         element.Key,
+			Size:         int64(element.Size),
+			IsDir:        isDir,
+			LastModified: lastModified,
+			ETag:         strings.Trim(element.Etag, `"`),
+			StorageClass: element.StorageClass,
+			Owner:        element.Owner.DisplayName,
+		}
+		if bucketFile.Owner == "" {
+			bucketFile.Owner = element.Owner.ID
 		}
+
+		b.Files = append(b.Files, bucketFile)
 	}
+}
 
-	return bucket, nil
+// Write attempts to anonymously upload a canary object to a given bucket within AWS and
+// removes it again so no debris is left behind. A canary that could not be removed is returned
+// with CleanupFailed set alongside the error.
+func (a AwsScanner) Write(name string) (result *WriteResult, err error) {
+	return a.WriteContext(context.Background(), name)
+}
+
+// WriteContext attempts the canary object upload and removal bound to the context
+func (a AwsScanner) WriteContext(ctx context.Context, name string) (result *WriteResult, err error) {
+	defer func() { err = providerError(err, awsName) }()
+
+	if err = checkName(awsName, name, generator.ValidAwsName); err != nil {
+		return nil, err
+	}
+
+	uri, err := a.bucketURI(awsURI, name)
+	if err != nil {
+		return nil, err
+	}
+
+	canary, err := canaryName()
+	if err != nil {
+		return nil, err
+	}
+	result = &WriteResult{
+		Canary: uri + "/" + canary,
+	}
+
+	client := a.httpClient(false)
+	bucket := &Bucket{Name: name}
+
+	var redirects int
+	for !result.Writable {
+		resp, err := putCanary(ctx, client, result.Canary)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+
+		if region := resp.Header.Get(awsRegionHeader); region != "" {
+			bucket.Region = region
+		}
+
+		switch resp.StatusCode {
+		case 200:
+			result.Writable = true
+		case 403, 404:
+			return result, nil
+		case 301, 307:
+			// Bucket lives in another region so upload to its regional endpoint
+			redirects++
+			regionalURI := ""
+			if redirects <= awsMaxRedirects {
+				regionalURI, err = a.redirectURI(ctx, client, bucket, resp)
+				if err != nil {
+					return nil, err
+				}
+			}
+			if regionalURI == "" || regionalURI == uri {
+				return result, &StatusError{URI: result.Canary, StatusCode: resp.StatusCode, Status: resp.Status}
+			}
+			uri = regionalURI
+			result.Canary = uri + "/" + canary
+		default:
+			return result, &StatusError{URI: result.Canary, StatusCode: resp.StatusCode, Status: resp.Status}
+		}
+	}
+
+	// Clean up the canary object
+	resp, err := deleteCanary(ctx, client, result.Canary)
+	if err != nil {
+		result.CleanupFailed = true
+		return result, fmt.Errorf("Failed to delete canary object %s: %w", result.Canary, err)
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case 200, 204:
+		result.Deletable = true
+	default:
+		result.CleanupFailed = true
+		statusErr := &StatusError{URI: result.Canary, StatusCode: resp.StatusCode, Status: resp.Status}
+		return result, fmt.Errorf("Failed to delete canary object %s: %w", result.Canary, statusErr)
+	}
+
+	return result, nil
 }
 
-// Write attempts to write a temporary file to a given bucket within AWS
-func (a AwsScanner) Write(name string) (isWritable bool, err error) {
-	if strings.Trim(name, " ") == "" {
-		return false, errors.New("Blank strings not accepted for bucket name")
+// putCanary uploads the canary object. An upload cut short by the scan's cancellation is removed
+// again as it may have landed before the cancellation.
+func putCanary(ctx context.Context, client *http.Client, canary string) (resp *http.Response, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, canary, strings.NewReader(canaryBody()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "text/plain")
+
+	resp, err = client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			if resp, err := deleteCanary(ctx, client, canary); err == nil {
+				resp.Body.Close()
+			}
+		}
+		return nil, &RequestError{Method: http.MethodPut, URI: canary, Err: err}
 	}
+	return resp, nil
+}
 
-	//url := strings.Replace(azureURI, bucketName, name, 1)
+// deleteCanary sends the removal of the canary object detached from the scan's cancellation, so
+// an uploaded canary is still removed once the scan is interrupted or its deadline passes
+func deleteCanary(ctx context.Context, client *http.Client, canary string) (resp *http.Response, err error) {
+	cleanupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), canaryCleanupTimeout)
+	resp, err = doRequest(cleanupCtx, client, http.MethodDelete, canary, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = cancelOnClose{resp.Body, cancel}
+	return resp, nil
+}
 
-	// TODO implement
+// cancelOnClose releases the context of a response body once it is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
 
-	return false, errors.New("AWSWriter is currently not supported")
+func (c cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
 }
 
 // GetProviderName returns the given Cloud Provider's name for the scanner
@@ -1,9 +1,15 @@
 package bucketscanner_test
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"gitlab.com/cjbarker/bucketscanner"
+	"net/http"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 const (
@@ -12,6 +18,19 @@ const (
 	PublicBucket  = "listing-test"
 )
 
+// newAwsStore returns a fake S3 store serving the invalid, private and public test buckets
+func newAwsStore(t *testing.T) *fakeStore {
+	store := newFakeStore(t, awsFlavour)
+	store.add(PrivateBucket, fakePrivate, nil)
+	store.add(PublicBucket, fakePublic, map[string]string{
+		"empty folder/":              "",
+		"empty folder/empty folder/": "",
+		"index-bucketname.html":      "<html>bucketname</html>",
+		"index-null.html":            "<html>null</html>",
+	})
+	return store
+}
+
 func TestGetAwsProviderName(t *testing.T) {
 	var expected = "Amazon Simple Storage Service (S3)"
 	aws := &bucketscanner.AwsScanner{}
@@ -21,8 +40,10 @@ func TestGetAwsProviderName(t *testing.T) {
 }
 
 func TestReadAws(t *testing.T) {
+	store := newAwsStore(t)
+
 	// Empty bucket name
-	aws := &bucketscanner.AwsScanner{}
+	aws := bucketscanner.NewAwsScanner(store.options()...)
 	_, err := aws.Read("   ")
 	if err == nil {
 		t.Errorf("Error should occur when empty bucket name string is attempted to be retrieved.")
@@ -31,7 +52,7 @@ func TestReadAws(t *testing.T) {
 	// Invalid bucket
 	bucket, err := aws.Read(InvalidBucket)
 	if err != nil {
-		t.Errorf("Was expecting bucket %s to provide Invalid state, but got error: %s", InvalidBucket, err.Error())
+		t.Fatalf("Was expecting bucket %s to provide Invalid state, but got error: %s", InvalidBucket, err.Error())
 	}
 	if bucket.State != bucketscanner.Invalid {
 		t.Errorf("Bucket state error, got: %d, expected %d", bucket.State, bucketscanner.Invalid)
@@ -40,7 +61,7 @@ func TestReadAws(t *testing.T) {
 	// Private bucket
 	bucket, err = aws.Read(PrivateBucket)
 	if err != nil {
-		t.Errorf("Was expecting bucket %s to provide Private state, but got error: %s", PrivateBucket, err.Error())
+		t.Fatalf("Was expecting bucket %s to provide Private state, but got error: %s", PrivateBucket, err.Error())
 	}
 	if bucket.State != bucketscanner.Private {
 		t.Errorf("Bucket state error, got: %d, expected %d", bucket.State, bucketscanner.Private)
@@ -49,11 +70,14 @@ func TestReadAws(t *testing.T) {
 	// Public bucket
 	bucket, err = aws.Read(PublicBucket)
 	if err != nil {
-		t.Errorf("Was expecting bucket %s to provide Public state, but got error: %s", PublicBucket, err.Error())
+		t.Fatalf("Was expecting bucket %s to provide Public state, but got error: %s", PublicBucket, err.Error())
 	}
 	if bucket.State != bucketscanner.Public {
 		t.Errorf("Bucket state error, got: %d, expected %d", bucket.State, bucketscanner.Public)
 	}
+	if bucket.NoFiles != 4 || bucket.TotalSize != 40 {
+		t.Errorf("Bucket totals error, got: %d files of %d bytes, expected 4 files of 40 bytes", bucket.NoFiles, bucket.TotalSize)
+	}
 
 	// Valid JSON
 	jsonStr, err := json.Marshal(bucket)
@@ -65,10 +89,234 @@ func TestReadAws(t *testing.T) {
 	}
 }
 
+func TestReadAwsThrottled(t *testing.T) {
+	store := newFakeStore(t, awsFlavour)
+	store.add("throttled", fakeThrottled, nil)
+
+	bucket, err := bucketscanner.NewAwsScanner(store.options()...).Read("throttled")
+	if err != nil {
+		t.Fatalf("Unexpected read error: %s", err.Error())
+	}
+	if bucket.State != bucketscanner.Public {
+		t.Errorf("Bucket state error, got: %d, expected %d", bucket.State, bucketscanner.Public)
+	}
+}
+
+func TestReadAwsRetryAfter(t *testing.T) {
+	store := newFakeStore(t, awsFlavour)
+	store.add("throttled", fakeThrottled, nil).retryAfter = "1"
+	store.add(PublicBucket, fakePublic, nil)
+
+	aws := bucketscanner.NewAwsScanner(store.options()...)
+	start := time.Now()
+	bucket, err := aws.Read("throttled")
+	if err != nil {
+		t.Fatalf("Unexpected read error: %s", err.Error())
+	}
+	if bucket.State != bucketscanner.Public {
+		t.Errorf("Bucket state error, got: %s, expected %s", bucket.State, bucketscanner.Public)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("Read should pause for the Retry-After, took %s", elapsed)
+	}
+
+	// the throttling slowed down the scanner's other requests
+	for i := 0; i < 3; i++ {
+		if _, err = aws.Read(PublicBucket); err != nil {
+			t.Fatalf("Unexpected read error: %s", err.Error())
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 1500*time.Millisecond {
+		t.Errorf("Scanner requests should be paced after throttling, took %s", elapsed)
+	}
+}
+
+func TestReadAwsRedirect(t *testing.T) {
+	regional := newFakeStore(t, awsFlavour)
+	regional.add("moved", fakePublic, map[string]string{"index.html": "moved"}).region = "eu-west-1"
+
+	store := newFakeStore(t, awsFlavour)
+	store.add("moved", fakeRedirect, nil).redirect = regional.URL
+
+	bucket, err := bucketscanner.NewAwsScanner(store.options()...).Read("moved")
+	if err != nil {
+		t.Fatalf("Unexpected read error: %s", err.Error())
+	}
+	if bucket.State != bucketscanner.Public || bucket.NoFiles != 1 {
+		t.Errorf("Bucket state error, got: %d with %d files, expected %d", bucket.State, bucket.NoFiles, bucketscanner.Public)
+	}
+	if bucket.Region != "eu-west-1" || bucket.URI != regional.URL+"/moved" {
+		t.Errorf("Bucket region error, got: %s at %s", bucket.Region, bucket.URI)
+	}
+}
+
+func TestReadAwsPagination(t *testing.T) {
+	store := newFakeStore(t, awsFlavour)
+	store.add("list-v2", fakePublic, numberedObjects(25)).pageSize = 10
+	v1 := store.add("list-v1", fakePublic, numberedObjects(25))
+	v1.pageSize = 10
+	v1.v1 = true
+
+	for _, name := range []string{"list-v2", "list-v1"} {
+		bucket, err := bucketscanner.NewAwsScanner(store.options()...).Read(name)
+		if err != nil {
+			t.Fatalf("Unexpected read error: %s", err.Error())
+		}
+		if bucket.NoFiles != 25 || bucket.Truncated {
+			t.Errorf("Bucket %s listing error, got: %d files, truncated %t", name, bucket.NoFiles, bucket.Truncated)
+		}
+		if bucket.Files[24].Name != "key-0024" {
+			t.Errorf("Bucket %s listing out of order, got: %s", name, bucket.Files[24].Name)
+		}
+
+		// capped listing
+		opts := append(store.options(), bucketscanner.WithMaxObjects(15))
+		bucket, err = bucketscanner.NewAwsScanner(opts...).Read(name)
+		if err != nil {
+			t.Fatalf("Unexpected read error: %s", err.Error())
+		}
+		if bucket.NoFiles != 15 || !bucket.Truncated {
+			t.Errorf("Bucket %s capped listing error, got: %d files, truncated %t", name, bucket.NoFiles, bucket.Truncated)
+		}
+	}
+}
+
+func TestWriteAwsRedirect(t *testing.T) {
+	regional := newFakeStore(t, awsFlavour)
+	regional.add("moved", fakeWritable, nil).region = "eu-west-1"
+
+	store := newFakeStore(t, awsFlavour)
+	store.add("moved", fakeRedirect, nil).redirect = regional.URL
+
+	result, err := bucketscanner.NewAwsScanner(store.options()...).Write("moved")
+	if err != nil {
+		t.Fatalf("Unexpected write error: %s", err.Error())
+	}
+	if !result.Writable || !result.Deletable {
+		t.Errorf("Canary should be written to the regional endpoint, got: %+v", *result)
+	}
+	if !strings.HasPrefix(result.Canary, regional.URL+"/moved/") || len(regional.puts) != 1 {
+		t.Errorf("Canary should be uploaded to the regional endpoint, got: %s", result.Canary)
+	}
+}
+
+// cancelAfterPut is a transport cancelling the scan once an upload's response is received
+type cancelAfterPut struct {
+	cancel context.CancelFunc
+}
+
+func (c cancelAfterPut) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if req.Method == http.MethodPut {
+		c.cancel()
+	}
+	return resp, err
+}
+
+func TestWriteAwsCancelledCleanup(t *testing.T) {
+	store := newAwsStore(t)
+	store.add("writable", fakeWritable, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	opts := append(store.options(), bucketscanner.WithHTTPClient(&http.Client{Transport: cancelAfterPut{cancel}}))
+
+	result, err := bucketscanner.NewAwsScanner(opts...).WriteContext(ctx, "writable")
+	if err != nil {
+		t.Fatalf("Unexpected write error: %s", err.Error())
+	}
+	if !result.Writable || !result.Deletable || result.CleanupFailed {
+		t.Errorf("Canary object should be removed after cancellation, got: %+v", *result)
+	}
+	if len(store.buckets["writable"].objects) != 0 {
+		t.Errorf("Canary object was left in the bucket after cancellation")
+	}
+}
+
 func TestWriteAws(t *testing.T) {
 	aws := &bucketscanner.AwsScanner{}
 	_, err := aws.Write("   ")
 	if err == nil {
 		t.Errorf("Error should occur when empty bucket name string is attempted to be retrieved.")
 	}
+
+	store := newAwsStore(t)
+	store.add("writable", fakeWritable, nil)
+	store.add("writeonly", fakeWriteOnly, nil)
+
+	tests := []struct {
+		name     string
+		expected bucketscanner.WriteResult
+	}{
+		{PrivateBucket, bucketscanner.WriteResult{}},
+		{InvalidBucket, bucketscanner.WriteResult{}},
+		{"writable", bucketscanner.WriteResult{Writable: true, Deletable: true}},
+		{"writeonly", bucketscanner.WriteResult{Writable: true, CleanupFailed: true}},
+	}
+
+	aws = bucketscanner.NewAwsScanner(store.options()...)
+	for _, test := range tests {
+		result, err := aws.Write(test.name)
+		if test.expected.CleanupFailed {
+			// a canary left behind is an error however its removal failed
+			if !errors.Is(err, bucketscanner.ErrHTTPStatus) || result == nil {
+				t.Fatalf("Failed canary removal of %s should return the result and a status error, got: %v", test.name, err)
+			}
+		} else if err != nil {
+			t.Fatalf("Unexpected write error for %s: %s", test.name, err.Error())
+		}
+		if !strings.Contains(result.Canary, "/"+test.name+"/bucketscanner-canary-") {
+			t.Errorf("Invalid canary object for %s, got: %s", test.name, result.Canary)
+		}
+
+		result.Canary = ""
+		if *result != test.expected {
+			t.Errorf("Write result error for %s, got: %+v, expected %+v", test.name, *result, test.expected)
+		}
+	}
+
+	if len(store.buckets["writable"].objects) != 0 {
+		t.Errorf("Canary object was not cleaned up from writable bucket")
+	}
+	if len(store.puts) != 2 {
+		t.Errorf("Expected 2 canary uploads, got: %v", store.puts)
+	}
+}
+
+func TestReadAwsFileMetadata(t *testing.T) {
+	store := newAwsStore(t)
+
+	bucket, err := bucketscanner.NewAwsScanner(store.options()...).Read(PublicBucket)
+	if err != nil {
+		t.Fatalf("Unexpected read error: %s", err.Error())
+	}
+
+	file := bucket.Files[2]
+	expected := bucketscanner.File{
+		Name:         "index-bucketname.html",
+		Size:         23,
+		LastModified: time.Date(2018, 4, 11, 18, 31, 16, 0, time.UTC),
+		ETag:         "3fea1aee5781dbdd25fcffbc0c08f257",
+		StorageClass: "STANDARD",
+		Owner:        "webfile",
+	}
+	if !reflect.DeepEqual(file, expected) {
+		t.Errorf("File metadata error, got: %+v, expected %+v", file, expected)
+	}
+
+	file.Body = []byte("downloaded")
+	jsonStr, err := json.Marshal(file)
+	if err != nil {
+		t.Fatalf("Unable to marshall file to JSON due to error: %s", err.Error())
+	}
+	var fields map[string]interface{}
+	json.Unmarshal(jsonStr, &fields)
+	for _, field := range []string{"name", "directory", "size", "lastModified", "etag", "storageClass", "owner"} {
+		if _, ok := fields[field]; !ok {
+			t.Errorf("File JSON missing %s field: %s", field, jsonStr)
+		}
+	}
+	if _, ok := fields["Body"]; ok {
+		t.Errorf("File JSON should not include the body: %s", jsonStr)
+	}
 }
@@ -1,19 +1,79 @@
 package bucketscanner
 
 import (
+	"context"
+	"encoding/xml"
 	"errors"
+	"gitlab.com/cjbarker/bucketscanner/generator"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
 	"strings"
+	"time"
 )
 
 // Cloud Provider Bucket Constant
 // https://docs.microsoft.com/en-us/azure/storage/blobs/storage-dotnet-how-to-use-blobs
+// https://docs.microsoft.com/en-us/rest/api/storageservices/list-blobs
 const (
-	azureName = "Azure Blob Storage"
-	azureURI  = "https://storagesample.blob.core.windows.net/" + bucketName
+	azureName      = "Azure Blob Storage"
+	azureURI       = "https://" + accountName + ".blob.core.windows.net/" + bucketName
+	azureListQuery = "?restype=container&comp=list"
+)
+
+const accountName string = "[replace-account-name]"
+
+// Azure Storage error codes used to classify the container state
+const (
+	azureResourceNotFound         = "ResourceNotFound"
+	azureContainerNotFound        = "ContainerNotFound"
+	azurePublicAccessNotPermitted = "PublicAccessNotPermitted"
+	azureServerBusy               = "ServerBusy"
 )
 
 // AzureScanner is struct for cloud scanner of Azure
 type AzureScanner struct {
+	options
+}
+
+// NewAzureScanner returns an Azure scanner configured by the options e.g. to scan an Azurite emulator
+func NewAzureScanner(opts ...Option) *AzureScanner {
+	return &AzureScanner{options: newOptions(opts)}
+}
+
+// EnumerationResults is the analyzed results read from a given Azure container
+type EnumerationResults struct {
+	XMLName       xml.Name `xml:"EnumerationResults"`
+	ContainerName string   `xml:"ContainerName,attr"`
+	Prefix        string
+	Marker        string
+	MaxResults    int
+	Blobs         []Blob `xml:"Blobs>Blob"`
+	NextMarker    string
+}
+
+// Blob is the XML contents of the container (one per file)
+type Blob struct {
+	XMLName    xml.Name `xml:"Blob"`
+	Name       string
+	Properties BlobProperties
+}
+
+// BlobProperties are the XML properties of a given blob
+type BlobProperties struct {
+	LastModified  string `xml:"Last-Modified"`
+	Etag          string
+	ContentLength int64  `xml:"Content-Length"`
+	ContentType   string `xml:"Content-Type"`
+	BlobType      string
+}
+
+// azureError is the XML error body returned by Azure Storage
+type azureError struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string
+	Message string
 }
 
 // GetProviderName returns the given Cloud Provider's name for the scanner
@@ -21,33 +81,198 @@ func (a AzureScanner) GetProviderName() (cloudProviderName string) {
 	return azureName
 }
 
+// splitAzureName splits an account/container target into its storage account and container.
+// A name without a container is treated as both the account and the container name.
+func splitAzureName(name string) (account string, container string) {
+	name = strings.Trim(name, " /")
+	if idx := strings.Index(name, "/"); idx > -1 {
+		return name[:idx], strings.Trim(name[idx+1:], "/")
+	}
+	return name, name
+}
+
+// containerURI returns the URI of the account's container on the configured endpoint, or else
+// the Azure Blob Storage endpoint of the account
+func (a AzureScanner) containerURI(account string, container string) (uri string, err error) {
+	if a.endpoint == "" {
+		uri = strings.Replace(azureURI, accountName, account, 1)
+		return strings.Replace(uri, bucketName, container, 1), nil
+	}
+
+	// account is the host for virtual host addressing e.g. account.blob.core.windows.net
+	uri, err = a.bucketURI("", account)
+	if err != nil {
+		return "", err
+	}
+	return uri + "/" + container, nil
+}
+
+// Read establishes HTTP connection and anonymously lists the blobs of an account/container
 func (a AzureScanner) Read(name string) (bucket *Bucket, err error) {
+	return a.ReadContext(context.Background(), name)
+}
+
+// ReadContext anonymously lists the blobs of an account/container bound to the context
+func (a AzureScanner) ReadContext(ctx context.Context, name string) (bucket *Bucket, err error) {
+	defer func() { err = providerError(err, azureName) }()
+
 	if strings.Trim(name, " ") == "" {
-		return nil, errors.New("Blank strings not accepted for bucket name")
+		return nil, &NameError{Provider: azureName, Name: name, Reason: "Blank strings not accepted for bucket name"}
 	}
 
-	//	url := strings.Replace(azureURI, bucketName, name, 1)
+	account, container := splitAzureName(name)
+	if account == "" || container == "" {
+		return nil, &NameError{Provider: azureName, Name: name, Reason: "Azure bucket name must be in account/container form"}
+	}
+	if err = checkName(azureName, account+"/"+container, generator.ValidAzureName); err != nil {
+		return nil, err
+	}
 
-	// TODO implement
-	/*
-		bucket = &Bucket{
-			provider: g.Getprovider(),
-			name:     name,
+	uri, err := a.containerURI(account, container)
+	if err != nil {
+		return nil, err
+	}
+
+	client := a.httpClient(true)
+	bucket = &Bucket{
+		Provider: azureName,
+		Name:     name,
+		URI:      uri,
+		State:    Unknown,
+		Scanned:  time.Now(),
+		client:   client,
+	}
+
+	var throttles int
+	var marker string
+
+	for {
+		listURI := uri + azureListQuery
+		if marker != "" {
+			listURI += "&marker=" + url.QueryEscape(marker)
+		}
+
+		resp, err := doRequest(ctx, client, http.MethodGet, listURI, nil)
+		if err != nil {
+			// storage account does not resolve so the container cannot exist
+			var dnsErr *net.DNSError
+			if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+				bucket.State = Invalid
+				return bucket, nil
+			}
+			return nil, err
+		}
+
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
 		}
+
+		if resp.StatusCode == 200 {
+			var result EnumerationResults
+			err = xml.Unmarshal(body, &result)
+			if err != nil {
+				return nil, err
+			}
+
+			bucket.State = Public
+			blobs := result.Blobs
+			if a.maxObjects > 0 && int(bucket.NoFiles)+len(blobs) > a.maxObjects {
+				blobs = blobs[:a.maxObjects-int(bucket.NoFiles)]
+				bucket.Truncated = true
+			}
+			bucket.addBlobs(blobs)
+
+			if bucket.Truncated || result.NextMarker == "" {
+				return bucket, nil
+			}
+			if a.maxObjects > 0 && int(bucket.NoFiles) >= a.maxObjects {
+				bucket.Truncated = true
+				return bucket, nil
+			}
+			marker = result.NextMarker
+			continue
+		}
+
+		switch azureErrorCode(resp, body) {
+		case azureContainerNotFound, azureResourceNotFound:
+			// anonymous callers receive ResourceNotFound for missing containers
+			bucket.State = Invalid
+		case azurePublicAccessNotPermitted:
+			bucket.State = Private
+		case azureServerBusy:
+			// ServerBusy pauses the provider's rate limiter before the retry
+			throttles++
+			if throttles <= maxThrottleRetries {
+				continue
+			}
+			bucket.State = RateLimited
+		default:
+			switch resp.StatusCode {
+			case 401, 403, 409:
+				bucket.State = Private
+			case 404:
+				bucket.State = Invalid
+			}
+		}
+
 		return bucket, nil
-	*/
+	}
+}
+
+// azureErrorCode returns the Azure Storage error code from the response header or XML body
+func azureErrorCode(resp *http.Response, body []byte) (code string) {
+	code = resp.Header.Get("x-ms-error-code")
+	if code != "" {
+		return code
+	}
 
-	return nil, errors.New("Azure Scanner is currently not supported")
+	var azErr azureError
+	if xml.Unmarshal(body, &azErr) == nil {
+		code = azErr.Code
+	}
+	return code
 }
 
-func (a AzureScanner) Write(name string) (isWritable bool, err error) {
-	if strings.Trim(name, " ") == "" {
-		return false, errors.New("Blank strings not accepted for bucket name")
+// addBlobs appends the listed container blobs as files and tallies the bucket totals
+func (b *Bucket) addBlobs(blobs []Blob) {
+	for _, blob := range blobs {
+		b.NoFiles++
+		b.TotalSize += blob.Properties.ContentLength
+
+		// unparsable timestamps are left as the zero time
+		lastModified, _ := http.ParseTime(blob.Properties.LastModified)
+
+		bucketFile := File{
+			Name:         blob.Name,
+			Size:         blob.Properties.ContentLength,
+			IsDir:        strings.HasSuffix(blob.Name, "/"),
+			LastModified: lastModified,
+			ETag:         strings.Trim(blob.Properties.Etag, `"`),
+			ContentType:  blob.Properties.ContentType,
+		}
+
+		b.Files = append(b.Files, bucketFile)
+	}
+}
+
+// Write attempts to write a temporary file to a given container within Azure
+func (a AzureScanner) Write(name string) (result *WriteResult, err error) {
+	return a.WriteContext(context.Background(), name)
+}
+
+// WriteContext attempts to write a temporary file to a given container within Azure bound to the context
+func (a AzureScanner) WriteContext(ctx context.Context, name string) (result *WriteResult, err error) {
+	defer func() { err = providerError(err, azureName) }()
+
+	if err = checkName(azureName, name, generator.ValidAzureName); err != nil {
+		return nil, err
 	}
 
 	//url := strings.Replace(azureURI, bucketName, name, 1)
 
 	// TODO implement
 
-	return false, errors.New("Azure Writer is currently not supported")
+	return nil, &UnsupportedError{Provider: azureName, Operation: "Writer"}
 }
@@ -20,3 +20,44 @@ func TestWriteAzure(t *testing.T) {
 		t.Errorf("Error should occur when empty bucket name string is attempted to be retrieved.")
 	}
 }
+
+func TestReadAzure(t *testing.T) {
+	store := newFakeStore(t, azureFlavour)
+	store.add("account/"+PrivateBucket, fakePrivate, nil)
+	store.add("account/"+PublicBucket, fakePublic, numberedObjects(25)).pageSize = 10
+	store.add("account/throttled", fakeThrottled, nil)
+
+	// Empty bucket name
+	azure := bucketscanner.NewAzureScanner(store.options()...)
+	_, err := azure.Read("   ")
+	if err == nil {
+		t.Errorf("Error should occur when empty bucket name string is attempted to be retrieved.")
+	}
+
+	tests := []struct {
+		name     string
+		expected bucketscanner.BucketState
+		noFiles  int64
+	}{
+		{"account/" + InvalidBucket, bucketscanner.Invalid, 0},
+		{InvalidBucket, bucketscanner.Invalid, 0},
+		{"account/" + PrivateBucket, bucketscanner.Private, 0},
+		{"account/" + PublicBucket, bucketscanner.Public, 25},
+		{"account/throttled", bucketscanner.Public, 0},
+	}
+
+	for _, test := range tests {
+		bucket, err := azure.Read(test.name)
+		if err != nil {
+			t.Fatalf("Was expecting bucket %s to provide state %d, but got error: %s", test.name, test.expected, err.Error())
+		}
+		if bucket.State != test.expected || bucket.NoFiles != test.noFiles {
+			t.Errorf("Bucket %s state error, got: %d with %d files, expected %d with %d files", test.name, bucket.State, bucket.NoFiles, test.expected, test.noFiles)
+		}
+		for _, file := range bucket.Files {
+			if file.ContentType != "text/plain" || file.LastModified.IsZero() {
+				t.Errorf("Bucket %s file metadata error, got: %+v", test.name, file)
+			}
+		}
+	}
+}
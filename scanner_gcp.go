@@ -1,8 +1,10 @@
 package bucketscanner
 
 import (
-	"errors"
-	"strings"
+	"context"
+	"gitlab.com/cjbarker/bucketscanner/generator"
+	"net/http"
+	"time"
 )
 
 // Cloud Provider Bucket Constant
@@ -14,6 +16,12 @@ const (
 
 // GcpScanner is struct for cloud scanner of Google Cloud Platform
 type GcpScanner struct {
+	options
+}
+
+// NewGcpScanner returns a GCP scanner configured by the options e.g. to scan a GCS emulator
+func NewGcpScanner(opts ...Option) *GcpScanner {
+	return &GcpScanner{options: newOptions(opts)}
 }
 
 // GetProviderName returns the given Cloud Provider's name for the scanner
@@ -21,33 +29,91 @@ func (g GcpScanner) GetProviderName() (cloudProviderName string) {
 	return gcpName
 }
 
+// Read establishes HTTP connection and reads the contents from the bucket via the GCS XML API
 func (g GcpScanner) Read(name string) (bucket *Bucket, err error) {
-	if strings.Trim(name, " ") == "" {
-		return nil, errors.New("Blank strings not accepted for bucket name")
+	return g.ReadContext(context.Background(), name)
+}
+
+// ReadContext establishes HTTP connection bound to the context and reads the contents from the bucket
+func (g GcpScanner) ReadContext(ctx context.Context, name string) (bucket *Bucket, err error) {
+	defer func() { err = providerError(err, gcpName) }()
+
+	if err = checkName(gcpName, name, generator.ValidGcpName); err != nil {
+		return nil, err
 	}
 
-	//	url := strings.Replace(gcpURI, bucketName, name, 1)
+	url, err := g.bucketURI(gcpURI, name)
+	if err != nil {
+		return nil, err
+	}
 
-	// TODO implement
-	/*
-		bucket = &Bucket{
-			provider: g.Getprovider(),
-			name:     name,
+	client := g.httpClient(true)
+	bucket = &Bucket{
+		Provider: gcpName,
+		Name:     name,
+		URI:      url,
+		State:    Unknown,
+		Scanned:  time.Now(),
+		client:   client,
+	}
+
+	var throttles int
+
+	// Parse State
+	for bucket.State == Unknown {
+		// Head check before deeper analysis
+		resp, err := doRequest(ctx, client, http.MethodHead, url, nil)
+		if err != nil {
+			return nil, err
 		}
-		return bucket, nil
-	*/
+		resp.Body.Close()
 
-	return nil, errors.New("GcpScanner is currently not supported")
+		switch resp.StatusCode {
+		case 200:
+			bucket.State = Public
+		case 403:
+			bucket.State = Private
+		case 404:
+			bucket.State = Invalid
+		case 429:
+			// Too Many Requests pauses the provider's rate limiter before the retry
+			throttles++
+			if throttles > maxThrottleRetries {
+				bucket.State = RateLimited
+			}
+		default:
+			return bucket, nil
+		}
+	}
+
+	// Retrieve available HTTP payload
+	if bucket.State == Public {
+		// GCS XML API responds with the S3 compatible ListBucketResult
+		err = bucket.listBucket(ctx, client, url, g.maxObjects)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return bucket, nil
+}
+
+// Write attempts to write a temporary file to a given bucket within GCP
+func (g GcpScanner) Write(name string) (result *WriteResult, err error) {
+	return g.WriteContext(context.Background(), name)
 }
 
-func (g GcpScanner) Write(name string) (isWritable bool, err error) {
-	if strings.Trim(name, " ") == "" {
-		return false, errors.New("Blank strings not accepted for bucket name")
+// WriteContext attempts to write a temporary file to a given bucket within GCP bound to the context
+func (g GcpScanner) WriteContext(ctx context.Context, name string) (result *WriteResult, err error) {
+	defer func() { err = providerError(err, gcpName) }()
+
+	if err = checkName(gcpName, name, generator.ValidGcpName); err != nil {
+		return nil, err
 	}
 
 	//url := strings.Replace(azureURI, bucketName, name, 1)
 
 	// TODO implement
 
-	return false, errors.New("GcpWWriter is currently not supported")
+	return nil, &UnsupportedError{Provider: gcpName, Operation: "Writer"}
 }
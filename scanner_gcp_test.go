@@ -20,3 +20,38 @@ func TestWriteGcp(t *testing.T) {
 		t.Errorf("Error should occur when empty bucket name string is attempted to be retrieved.")
 	}
 }
+
+func TestReadGcp(t *testing.T) {
+	store := newFakeStore(t, gcpFlavour)
+	store.add(PrivateBucket, fakePrivate, nil)
+	store.add(PublicBucket, fakePublic, numberedObjects(25)).pageSize = 10
+	store.add("throttled", fakeThrottled, nil)
+
+	// Empty bucket name
+	gcp := bucketscanner.NewGcpScanner(store.options()...)
+	_, err := gcp.Read("   ")
+	if err == nil {
+		t.Errorf("Error should occur when empty bucket name string is attempted to be retrieved.")
+	}
+
+	tests := []struct {
+		name     string
+		expected bucketscanner.BucketState
+		noFiles  int64
+	}{
+		{InvalidBucket, bucketscanner.Invalid, 0},
+		{PrivateBucket, bucketscanner.Private, 0},
+		{PublicBucket, bucketscanner.Public, 25},
+		{"throttled", bucketscanner.Public, 0},
+	}
+
+	for _, test := range tests {
+		bucket, err := gcp.Read(test.name)
+		if err != nil {
+			t.Fatalf("Was expecting bucket %s to provide state %d, but got error: %s", test.name, test.expected, err.Error())
+		}
+		if bucket.State != test.expected || bucket.NoFiles != test.noFiles {
+			t.Errorf("Bucket %s state error, got: %d with %d files, expected %d with %d files", test.name, bucket.State, bucket.NoFiles, test.expected, test.noFiles)
+		}
+	}
+}
@@ -16,11 +16,12 @@ func TestBuild(t *testing.T) {
 }
 
 func TestDownload(t *testing.T) {
-	aws := &bucketscanner.AwsScanner{}
+	store := newAwsStore(t)
+	aws := bucketscanner.NewAwsScanner(store.options()...)
 
 	bucket, err := aws.Read(PublicBucket)
 	if err != nil {
-		t.Errorf("Was expecting bucket %s to provide Public state, but got error: %s", PublicBucket, err.Error())
+		t.Fatalf("Was expecting bucket %s to provide Public state, but got error: %s", PublicBucket, err.Error())
 	}
 
 	/*
@@ -51,7 +52,7 @@ func TestDownload(t *testing.T) {
 	tmpFilename = os.TempDir()
 	zipFile, err := bucket.Download(tmpFilename)
 	if err != nil {
-		t.Errorf("Unable to download bucket to dir due to error %s", err.Error())
+		t.Fatalf("Unable to download bucket to dir due to error %s", err.Error())
 	}
 
 	os.Remove(*zipFile)
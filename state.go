@@ -0,0 +1,73 @@
+package bucketscanner
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// bucketStateNames are the names of the bucket states in iota order
+var bucketStateNames = []string{"unknown", "invalid", "private", "public", "rate_limited"}
+
+// String returns the name of the bucket state e.g. public
+func (s BucketState) String() string {
+	if s >= 0 && int(s) < len(bucketStateNames) {
+		return bucketStateNames[s]
+	}
+	return "BucketState(" + strconv.Itoa(int(s)) + ")"
+}
+
+// ParseBucketState returns the bucket state of the case insensitive name, or of its integer for
+// compatibility with output from before states were named
+func ParseBucketState(name string) (BucketState, error) {
+	name = strings.ToLower(strings.Trim(name, " "))
+	for idx, stateName := range bucketStateNames {
+		if name == stateName {
+			return BucketState(idx), nil
+		}
+	}
+
+	if value, err := strconv.Atoi(name); err == nil && value >= 0 && value < len(bucketStateNames) {
+		return BucketState(value), nil
+	}
+	return Unknown, fmt.Errorf("invalid bucket state '%s'", name)
+}
+
+// MarshalText encodes the bucket state as its name
+func (s BucketState) MarshalText() ([]byte, error) {
+	if s < 0 || int(s) >= len(bucketStateNames) {
+		return nil, fmt.Errorf("invalid bucket state %d", int(s))
+	}
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText decodes the bucket state from its name or integer
+func (s *BucketState) UnmarshalText(text []byte) (err error) {
+	*s, err = ParseBucketState(string(text))
+	return err
+}
+
+// MarshalJSON encodes the bucket state as its name string e.g. "public"
+func (s BucketState) MarshalJSON() ([]byte, error) {
+	text, err := s.MarshalText()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(string(text))
+}
+
+// UnmarshalJSON decodes the bucket state from its name string or, as output before states were
+// named, its integer e.g. "public" or 3
+func (s *BucketState) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		return s.UnmarshalText([]byte(name))
+	}
+
+	var value int
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("invalid bucket state %s", data)
+	}
+	return s.UnmarshalText([]byte(strconv.Itoa(value)))
+}
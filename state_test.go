@@ -0,0 +1,93 @@
+package bucketscanner_test
+
+import (
+	"encoding/json"
+	"gitlab.com/cjbarker/bucketscanner"
+	"testing"
+)
+
+func TestBucketStateString(t *testing.T) {
+	tests := []struct {
+		state    bucketscanner.BucketState
+		expected string
+	}{
+		{bucketscanner.Unknown, "unknown"},
+		{bucketscanner.Invalid, "invalid"},
+		{bucketscanner.Private, "private"},
+		{bucketscanner.Public, "public"},
+		{bucketscanner.RateLimited, "rate_limited"},
+		{bucketscanner.BucketState(9), "BucketState(9)"},
+	}
+
+	for _, test := range tests {
+		if test.state.String() != test.expected {
+			t.Errorf("Bucket state string error, got: %s, expected %s", test.state.String(), test.expected)
+		}
+	}
+}
+
+func TestBucketStateJSON(t *testing.T) {
+	bucket := bucketscanner.Bucket{Name: "listing-test", State: bucketscanner.RateLimited}
+	jsonStr, err := json.Marshal(bucket)
+	if err != nil {
+		t.Fatalf("Unable to marshall bucket to JSON due to error: %s", err.Error())
+	}
+
+	var fields map[string]interface{}
+	json.Unmarshal(jsonStr, &fields)
+	if fields["state"] != "rate_limited" {
+		t.Errorf("Bucket state JSON error, got: %v", fields["state"])
+	}
+
+	var decoded bucketscanner.Bucket
+	if err = json.Unmarshal(jsonStr, &decoded); err != nil || decoded.State != bucketscanner.RateLimited {
+		t.Errorf("Bucket state JSON round trip error, got: %s, %v", decoded.State, err)
+	}
+
+	// names, case insensitive, and the integers output before states were named
+	inputs := map[string]bucketscanner.BucketState{
+		`{"state":"public"}`:  bucketscanner.Public,
+		`{"state":"PRIVATE"}`: bucketscanner.Private,
+		`{"state":3}`:         bucketscanner.Public,
+		`{"state":"1"}`:       bucketscanner.Invalid,
+		`{"state":0}`:         bucketscanner.Unknown,
+	}
+	for input, expected := range inputs {
+		var bucket bucketscanner.Bucket
+		if err := json.Unmarshal([]byte(input), &bucket); err != nil || bucket.State != expected {
+			t.Errorf("Bucket state JSON parse error for %s, got: %s, %v", input, bucket.State, err)
+		}
+	}
+
+	for _, input := range []string{`{"state":"exposed"}`, `{"state":7}`, `{"state":-1}`, `{"state":true}`} {
+		var bucket bucketscanner.Bucket
+		if err := json.Unmarshal([]byte(input), &bucket); err == nil {
+			t.Errorf("Error should occur parsing invalid bucket state %s", input)
+		}
+	}
+
+	if _, err := json.Marshal(bucketscanner.BucketState(7)); err == nil {
+		t.Errorf("Error should occur marshalling an invalid bucket state")
+	}
+}
+
+func TestBucketStateText(t *testing.T) {
+	states := map[bucketscanner.BucketState]bool{}
+	for _, state := range []bucketscanner.BucketState{bucketscanner.Public, bucketscanner.Private} {
+		states[state] = true
+	}
+
+	// text marshalling keys JSON maps by name
+	jsonStr, err := json.Marshal(states)
+	if err != nil || string(jsonStr) != `{"private":true,"public":true}` {
+		t.Errorf("Bucket state text marshal error, got: %s, %v", jsonStr, err)
+	}
+
+	var state bucketscanner.BucketState
+	if err := state.UnmarshalText([]byte("rate_limited")); err != nil || state != bucketscanner.RateLimited {
+		t.Errorf("Bucket state text parse error, got: %s, %v", state, err)
+	}
+	if _, err := bucketscanner.ParseBucketState("gone"); err == nil {
+		t.Errorf("Error should occur parsing an unknown bucket state name")
+	}
+}
@@ -0,0 +1,77 @@
+package bucketscanner
+
+import (
+	"sort"
+	"strings"
+)
+
+// treeNode is a directory of the prefix tree being built
+type treeNode struct {
+	dir   File
+	dirs  []*treeNode
+	files []File
+}
+
+// Tree folds the bucket's flat object keys into a prefix tree split on /. The returned root
+// directory has a blank name and each directory's Files are its sub directories, named by their
+// full prefix e.g. logs/2018/, followed by its files. Every directory's NoFiles and Size are the
+// count and aggregate size of all the files beneath it. Directory marker objects lend their
+// metadata to their directory. The number of directories is recorded on the bucket.
+func (b *Bucket) Tree() *File {
+	root := &treeNode{dir: File{IsDir: true}}
+	nodes := map[string]*treeNode{"": root}
+
+	// dir returns the directory of the prefix, adding it and any missing parents to the tree
+	var dir func(prefix string) *treeNode
+	dir = func(prefix string) *treeNode {
+		if node, ok := nodes[prefix]; ok {
+			return node
+		}
+
+		parent := dir(prefix[:strings.LastIndex(prefix[:len(prefix)-1], "/")+1])
+		node := &treeNode{dir: File{Name: prefix, IsDir: true}}
+		nodes[prefix] = node
+		parent.dirs = append(parent.dirs, node)
+		return node
+	}
+
+	for _, bucketFile := range b.Files {
+		if bucketFile.IsDir {
+			node := dir(bucketFile.Name)
+			node.dir = bucketFile
+			node.dir.Files = nil
+			continue
+		}
+
+		parent := dir(bucketFile.Name[:strings.LastIndex(bucketFile.Name, "/")+1])
+		parent.files = append(parent.files, bucketFile)
+	}
+
+	b.noDirs = int64(len(nodes) - 1)
+
+	tree := root.build()
+	return &tree
+}
+
+// build returns the directory with its sub directories, sorted by name, and files totalled
+func (n *treeNode) build() File {
+	dir := n.dir
+
+	sort.Slice(n.dirs, func(i, j int) bool {
+		return n.dirs[i].dir.Name < n.dirs[j].dir.Name
+	})
+	for _, child := range n.dirs {
+		sub := child.build()
+		dir.Size += sub.Size
+		dir.NoFiles += sub.NoFiles
+		dir.Files = append(dir.Files, sub)
+	}
+
+	for _, bucketFile := range n.files {
+		dir.Size += bucketFile.Size
+		dir.NoFiles++
+		dir.Files = append(dir.Files, bucketFile)
+	}
+
+	return dir
+}
@@ -0,0 +1,71 @@
+package bucketscanner_test
+
+import (
+	"gitlab.com/cjbarker/bucketscanner"
+	"testing"
+)
+
+func TestTree(t *testing.T) {
+	bucket := bucketscanner.Bucket{
+		Files: []bucketscanner.File{
+			{Name: "index.html", Size: 10},
+			{Name: "logs/", IsDir: true, Owner: "webfile"},
+			{Name: "logs/2018/app.log", Size: 100},
+			{Name: "logs/2018/db.log", Size: 200},
+			{Name: "logs/latest.log", Size: 5},
+			{Name: "backups/2017/12/db.sql", Size: 1000},
+			{Name: "empty/", IsDir: true},
+		},
+	}
+
+	tree := bucket.Tree()
+	if tree.Name != "" || !tree.IsDir || tree.NoFiles != 5 || tree.Size != 1315 {
+		t.Fatalf("Tree root error, got: %s with %d files of %d bytes", tree.Name, tree.NoFiles, tree.Size)
+	}
+
+	// sub directories sorted by name precede files
+	var names []string
+	for _, child := range tree.Files {
+		names = append(names, child.Name)
+	}
+	expected := []string{"backups/", "empty/", "logs/", "index.html"}
+	if len(names) != len(expected) {
+		t.Fatalf("Tree root contents error, got: %v, expected %v", names, expected)
+	}
+	for i := range expected {
+		if names[i] != expected[i] {
+			t.Errorf("Tree root contents error, got: %v, expected %v", names, expected)
+			break
+		}
+	}
+
+	tests := []struct {
+		dir     bucketscanner.File
+		name    string
+		noFiles int64
+		size    int64
+	}{
+		{tree.Files[0], "backups/", 1, 1000},
+		{tree.Files[0].Files[0], "backups/2017/", 1, 1000},
+		{tree.Files[0].Files[0].Files[0], "backups/2017/12/", 1, 1000},
+		{tree.Files[1], "empty/", 0, 0},
+		{tree.Files[2], "logs/", 3, 305},
+		{tree.Files[2].Files[0], "logs/2018/", 2, 300},
+	}
+	for _, test := range tests {
+		if test.dir.Name != test.name || !test.dir.IsDir || test.dir.NoFiles != test.noFiles || test.dir.Size != test.size {
+			t.Errorf("Tree directory error, got: %s with %d files of %d bytes, expected %s with %d files of %d bytes",
+				test.dir.Name, test.dir.NoFiles, test.dir.Size, test.name, test.noFiles, test.size)
+		}
+	}
+
+	if tree.Files[2].Owner != "webfile" {
+		t.Errorf("Directory marker metadata should be kept, got: %+v", tree.Files[2])
+	}
+	if latest := tree.Files[2].Files[1]; latest.Name != "logs/latest.log" || latest.IsDir {
+		t.Errorf("Tree file error, got: %+v", latest)
+	}
+	if len(bucket.Files) != 7 {
+		t.Errorf("Tree should not change the flat listing, got %d files", len(bucket.Files))
+	}
+}
@@ -0,0 +1,135 @@
+// Package wordlist lazily streams de-duplicated bucket names from wordlist files and stdin
+// so that large wordlists can be scanned with flat memory usage.
+package wordlist
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"strings"
+)
+
+// Stdin is the wordlist path denoting names are read from standard input
+const Stdin = "-"
+
+// gzipMagic are the leading bytes of a gzip stream
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// Deduper tracks the names already seen by their 64-bit FNV-1a hash rather than the name
+// itself. It is not safe for concurrent use.
+type Deduper struct {
+	seen map[uint64]struct{}
+}
+
+// NewDeduper returns an empty name de-duplicator
+func NewDeduper() *Deduper {
+	return &Deduper{seen: make(map[uint64]struct{})}
+}
+
+// Seen reports whether the name was already seen and records it otherwise
+func (d *Deduper) Seen(name string) bool {
+	hash := fnv.New64a()
+	hash.Write([]byte(name))
+	sum := hash.Sum64()
+
+	if _, ok := d.seen[sum]; ok {
+		return true
+	}
+	d.seen[sum] = struct{}{}
+	return false
+}
+
+// Reader streams the names of one or more wordlists, one name per line. Blank lines and
+// lines starting with # are skipped and gzip compressed wordlists are detected by content.
+type Reader struct {
+	Paths []string
+	Stdin io.Reader // Read for the Stdin path
+	Dedup *Deduper  // Shared so names can be de-duplicated across other inputs
+	err   error
+}
+
+// New returns a wordlist reader for the given paths reading the Stdin path from os.Stdin
+func New(paths ...string) *Reader {
+	return &Reader{
+		Paths: paths,
+		Stdin: os.Stdin,
+		Dedup: NewDeduper(),
+	}
+}
+
+// Names streams the de-duplicated names of every wordlist over the returned channel which is
+// closed once the wordlists are exhausted or a wordlist fails to be read; see Err
+func (r *Reader) Names() <-chan string {
+	names := make(chan string)
+
+	go func() {
+		defer close(names)
+
+		for _, path := range r.Paths {
+			r.err = r.read(path, names)
+			if r.err != nil {
+				return
+			}
+		}
+	}()
+
+	return names
+}
+
+// Err returns the error that stopped the names stream, if any, once its channel is closed
+func (r *Reader) Err() error {
+	return r.err
+}
+
+// read sends the names of the wordlist at the path to the names channel
+func (r *Reader) read(path string, names chan<- string) (err error) {
+	var input io.Reader
+
+	if path == Stdin {
+		if r.Stdin == nil {
+			return errors.New("No stdin available to read wordlist from")
+		}
+		input = r.Stdin
+	} else {
+		wordlist, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("Unable to open wordlist %s: %w", path, err)
+		}
+		defer wordlist.Close()
+		input = wordlist
+	}
+
+	buffered := bufio.NewReader(input)
+	magic, _ := buffered.Peek(len(gzipMagic))
+	if string(magic) == string(gzipMagic) {
+		gzipReader, err := gzip.NewReader(buffered)
+		if err != nil {
+			return fmt.Errorf("Unable to read gzip wordlist %s: %w", path, err)
+		}
+		defer gzipReader.Close()
+		input = gzipReader
+	} else {
+		input = buffered
+	}
+
+	scanner := bufio.NewScanner(input)
+	for scanner.Scan() {
+		name := strings.TrimSpace(scanner.Text())
+		if name == "" || strings.HasPrefix(name, "#") {
+			continue
+		}
+		if r.Dedup != nil && r.Dedup.Seen(name) {
+			continue
+		}
+		names <- name
+	}
+
+	if err = scanner.Err(); err != nil {
+		return fmt.Errorf("Unable to read wordlist %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package wordlist_test
+
+import (
+	"compress/gzip"
+	"gitlab.com/cjbarker/bucketscanner/wordlist"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNames(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wordlist")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir due to error: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	plain := filepath.Join(dir, "plain.txt")
+	err = ioutil.WriteFile(plain, []byte("# comment\nacme\n\n  acme-dev  \nacme\n"), 0600)
+	if err != nil {
+		t.Fatalf("Unable to write wordlist due to error: %s", err.Error())
+	}
+
+	compressed := filepath.Join(dir, "compressed")
+	dst, err := os.Create(compressed)
+	if err != nil {
+		t.Fatalf("Unable to create wordlist due to error: %s", err.Error())
+	}
+	gzipWriter := gzip.NewWriter(dst)
+	gzipWriter.Write([]byte("acme-dev\nacme-prod\n"))
+	gzipWriter.Close()
+	dst.Close()
+
+	reader := wordlist.New(plain, compressed, wordlist.Stdin)
+	reader.Stdin = strings.NewReader("acme-prod\nacme-backup\n")
+
+	var names []string
+	for name := range reader.Names() {
+		names = append(names, name)
+	}
+
+	if reader.Err() != nil {
+		t.Errorf("Unexpected wordlist error: %s", reader.Err().Error())
+	}
+
+	expected := []string{"acme", "acme-dev", "acme-prod", "acme-backup"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Invalid wordlist names, got: %v, expected %v", names, expected)
+	}
+}
+
+func TestNamesMissingFile(t *testing.T) {
+	reader := wordlist.New("does-not-exist.txt")
+	for range reader.Names() {
+		t.Errorf("No names should be read from a missing wordlist")
+	}
+
+	if reader.Err() == nil {
+		t.Errorf("Error should occur when a missing wordlist is read.")
+	}
+}
+
+func TestDeduper(t *testing.T) {
+	dedup := wordlist.NewDeduper()
+	if dedup.Seen("acme") {
+		t.Errorf("Name should not be seen before it is recorded")
+	}
+	if !dedup.Seen("acme") {
+		t.Errorf("Name should be seen after it is recorded")
+	}
+}